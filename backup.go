@@ -0,0 +1,389 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"go.etcd.io/bbolt"
+)
+
+// Backups are whole copies of the database kept in a `backups` directory
+// beside it, named after the database and the time they were taken (e.g.
+// `db.todaily-2026-10-15-210405`). One is taken each day the app is open, and
+// only the newest backup of each of the last `keepDaily` days, `keepWeekly`
+// weeks and `keepMonthly` months is kept.
+const (
+	keepDaily   = 7
+	keepWeekly  = 5
+	keepMonthly = 12
+
+	backupTimeFormat = "2006-01-02-150405"
+	// backupCheckInterval is how often the app checks whether a backup is due.
+	backupCheckInterval = time.Hour
+)
+
+type backupFile struct {
+	fpath string
+	taken time.Time
+}
+
+func backupDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+// listBackups returns the backups of the database at `dbPath`, newest first.
+func listBackups(dbPath string) ([]backupFile, error) {
+	dir := backupDir(dbPath)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(dbPath) + "-"
+	var backups []backupFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(name, prefix), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{fpath: filepath.Join(dir, name), taken: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].taken.After(backups[j].taken)
+	})
+	return backups, nil
+}
+
+// backup writes a consistent copy of the database to a new backup file and
+// returns its path.
+func (s *store) backup() (string, error) {
+	dbPath := s.db.Path()
+	dir := backupDir(dbPath)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	fpath := filepath.Join(dir, filepath.Base(dbPath)+"-"+time.Now().Format(backupTimeFormat))
+	// Write to a temporary file first so an interrupted backup is never
+	// mistaken for a good one.
+	tmp := fpath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, fpath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("writing backup: %w", err)
+	}
+	return fpath, nil
+}
+
+// backupIfDue takes a backup if there isn't one from today yet and then
+// removes the backups that are no longer kept.
+func (s *store) backupIfDue() error {
+	backups, err := listBackups(s.db.Path())
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	today := time.Now().Format("2006-01-02")
+	if len(backups) > 0 && backups[0].taken.Format("2006-01-02") == today {
+		return nil
+	}
+	if _, err := s.backup(); err != nil {
+		return err
+	}
+	return pruneBackups(s.db.Path())
+}
+
+// pruneBackups removes the backups of the database at `dbPath` that are no
+// longer kept.
+func pruneBackups(dbPath string) error {
+	backups, err := listBackups(dbPath)
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	keep := backupsToKeep(backups)
+	for _, b := range backups {
+		if keep[b.fpath] {
+			continue
+		}
+		if err := os.Remove(b.fpath); err != nil {
+			return fmt.Errorf("removing old backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// backupsToKeep returns the paths of the given backups (newest first) that
+// are the newest of their day, week or month, within the retention limits.
+func backupsToKeep(backups []backupFile) map[string]bool {
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	months := make(map[string]bool)
+	for _, b := range backups {
+		day := b.taken.Format("2006-01-02")
+		week := weekStart(b.taken).Format("2006-01-02")
+		month := b.taken.Format("2006-01")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[b.fpath] = true
+		}
+		if !weeks[week] && len(weeks) < keepWeekly {
+			weeks[week] = true
+			keep[b.fpath] = true
+		}
+		if !months[month] && len(months) < keepMonthly {
+			months[month] = true
+			keep[b.fpath] = true
+		}
+	}
+	return keep
+}
+
+// keepBackedUp takes a backup whenever one is due for as long as the app is
+// running.
+func keepBackedUp(s *store, updates chan<- any) {
+	for {
+		if err := s.backupIfDue(); err != nil {
+			updates <- backupFailed{err}
+		}
+		time.Sleep(backupCheckInterval)
+	}
+}
+
+// backupFailed is sent when a scheduled backup couldn't be taken.
+type backupFailed struct {
+	err error
+}
+
+// restoreBackup replaces the database at `dbPath` with the given backup. The
+// replaced database is moved aside rather than deleted, and its new path is
+// returned (or an empty string if there was no database).
+func restoreBackup(dbPath, backupPath string) (string, error) {
+	if err := checkNotInUse(dbPath); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(backupPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp := dbPath + ".restoring"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("copying backup: %w", err)
+	}
+
+	var movedTo string
+	if _, err := os.Stat(dbPath); err == nil {
+		movedTo = dbPath + ".replaced-" + time.Now().Format(backupTimeFormat)
+		if err := os.Rename(dbPath, movedTo); err != nil {
+			os.Remove(tmp)
+			return "", fmt.Errorf("moving the current database aside: %w", err)
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return movedTo, fmt.Errorf("putting the backup in place: %w", err)
+	}
+	return movedTo, nil
+}
+
+// checkNotInUse returns `errStoreLocked` if another process has the database
+// at `dbPath` open. Opening a damaged database fails after the file lock is
+// taken, so only a timeout means it's in use.
+func checkNotInUse(dbPath string) (err error) {
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			err = nil
+		}
+	}()
+	db, err := bbolt.Open(dbPath, 0o644, &bbolt.Options{Timeout: lockTimeout})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return errStoreLocked{fpath: dbPath}
+	}
+	if err == nil {
+		db.Close()
+	}
+	return nil
+}
+
+// restoreSplash is shown in place of the home screen when the database is too
+// damaged to open, offering to restore one of its backups.
+type restoreSplash struct {
+	updates chan<- any
+	err     errStoreCorrupt
+	backups []backupFile
+	buttons []widget.Clickable
+	list    widget.List
+	busy    bool
+}
+
+func (rs *restoreSplash) layout(gtx C, th *material.Theme) D {
+	for i := range rs.buttons {
+		if rs.buttons[i].Clicked() && !rs.busy {
+			rs.busy = true
+			go rs.restore(rs.backups[i])
+		}
+	}
+	muted := material.Body2(th, rs.err.err.Error())
+	muted.Color = mutedColor(th.Fg)
+	rows := []layout.Widget{
+		material.H6(th, "Todaily's database is damaged").Layout,
+		layout.Spacer{Height: 8}.Layout,
+		material.Body1(th, rs.err.fpath+" couldn't be opened.").Layout,
+		muted.Layout,
+		layout.Spacer{Height: 20}.Layout,
+	}
+	if len(rs.backups) == 0 {
+		rows = append(rows, material.Body1(th, "There are no backups to restore it from.").Layout)
+	} else {
+		rows = append(rows, material.Body1(th, "Restore it from one of these backups? The damaged file will be kept beside it.").Layout)
+		rows = append(rows, layout.Spacer{Height: 10}.Layout)
+	}
+	for i := range rs.backups {
+		b, btn := rs.backups[i], &rs.buttons[i]
+		rows = append(rows, func(gtx C) D {
+			return layout.Inset{Top: 4, Bottom: 4}.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, material.Body1(th, b.taken.Format("Monday, Jan 2 2006 at 3:04 PM")).Layout),
+					layout.Rigid(material.Button(th, btn, "Restore").Layout),
+				)
+			})
+		})
+	}
+	return layout.UniformInset(30).Layout(gtx, func(gtx C) D {
+		return material.List(th, &rs.list).Layout(gtx, len(rows), func(gtx C, i int) D {
+			return rows[i](gtx)
+		})
+	})
+}
+
+func (rs *restoreSplash) restore(b backupFile) {
+	movedTo, err := restoreBackup(rs.err.fpath, b.fpath)
+	if err != nil {
+		rs.updates <- splashErr(fmt.Errorf("restoring backup: %w", err))
+		return
+	}
+	rs.updates <- restoredBackup{
+		dbPath: rs.err.fpath,
+		notice: fmt.Sprintf("Restored the backup from %s. The damaged database was moved to %s.", b.taken.Format("Jan 2, 2006"), movedTo),
+	}
+}
+
+// splashCorrupt is sent instead of a `splashErr` when the database is too
+// damaged to open.
+type splashCorrupt struct {
+	err     errStoreCorrupt
+	backups []backupFile
+}
+
+// restoredBackup is sent once a backup has replaced a damaged database, which
+// can then be loaded again.
+type restoredBackup struct {
+	dbPath string
+	notice string
+}
+
+func runBackup(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "backup")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		fpath, err := s.backup()
+		if err != nil {
+			return err
+		}
+		if err := pruneBackups(s.db.Path()); err != nil {
+			return err
+		}
+		fmt.Fprintf(env.stdout, "Backed up to %s\n", fpath)
+		return nil
+	})
+}
+
+func runRestore(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "restore")
+	list := fs.Bool("list", false, "List the backups instead of restoring one.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageError(fs)
+	}
+	dbPath, err := resolveDBPath(env.path(*dbFile))
+	if err != nil {
+		return err
+	}
+	backups, err := listBackups(dbPath)
+	if err != nil {
+		return fmt.Errorf("listing backups: %w", err)
+	}
+	if *list {
+		for _, b := range backups {
+			fmt.Fprintf(env.stdout, "%s  %s\n", b.taken.Format("Mon Jan 2 2006 15:04"), b.fpath)
+		}
+		return nil
+	}
+	var backupPath string
+	switch {
+	case fs.NArg() == 1:
+		backupPath = env.path(fs.Arg(0))
+	case len(backups) > 0:
+		backupPath = backups[0].fpath
+	default:
+		return fmt.Errorf("there are no backups in %s", backupDir(dbPath))
+	}
+	movedTo, err := restoreBackup(dbPath, backupPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.stdout, "Restored %s\n", backupPath)
+	if movedTo != "" {
+		fmt.Fprintf(env.stdout, "The replaced database was moved to %s\n", movedTo)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand that works with a database without opening a window.
+type command struct {
+	args string
+	desc string
+	run  func(env *cmdEnv, args []string) error
+	// local commands need the database to themselves, so they're never
+	// forwarded to a running window (see ipc.go).
+	local bool
+	// readOnly commands never change the database, so a running window
+	// doesn't need to reload after running one.
+	readOnly bool
+}
+
+// commands returns all subcommands by name.
+func commands() map[string]command {
+	return map[string]command{
+		"add": {
+			args: "[-db file] [-days Mon,Wed | -weekdays | -every N | -per-week N] [-target N [-unit name]] [-json] name",
+			desc: "Add a new habit, which is due from today.",
+			run:  runAdd,
+		},
+		"backup": {
+			args:     "[-db file]",
+			desc:     "Take a backup of the database now.",
+			run:      runBackup,
+			readOnly: true,
+		},
+		"check": {
+			args: "[-db file] [-date YYYY-MM-DD] [-value N] [-json] habit",
+			desc: "Mark a habit as done, by its ID or (the start of) its name.",
+			run:  runCheck,
+		},
+		"config": {
+			args: "[-db file] [-json] [setting [value]]",
+			desc: "Show the settings, or show or change one of them (e.g. config day-start 2h).",
+			run:  runConfig,
+		},
+		"csv": {
+			args:     "[-db file] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-o file]",
+			desc:     "Write each day's habits out as CSV for spreadsheets.",
+			run:      runCSV,
+			readOnly: true,
+		},
+		"decrypt": {
+			args:  "[-db file]",
+			desc:  "Remove the database's passphrase, decrypting it.",
+			run:   runDecrypt,
+			local: true,
+		},
+		"encrypt": {
+			args:  "[-db file]",
+			desc:  "Encrypt the database with a passphrase, which is then needed to open it.",
+			run:   runEncrypt,
+			local: true,
+		},
+		"export": {
+			args:     "[-db file] [-o file]",
+			desc:     "Write the entire database out as JSON.",
+			run:      runExport,
+			readOnly: true,
+		},
+		"fsck": {
+			args: "[-db file] [-rebuild] [-json]",
+			desc: "Check the database for damaged or inconsistent entries, optionally rebuilding its summaries.",
+			run:  runFsck,
+		},
+		"import": {
+			args: "[-db file] [-replace] file",
+			desc: "Load a JSON export into the database.",
+			run:  runImport,
+		},
+		"import-csv": {
+			args: "[-db file] file",
+			desc: "Add habits and their history from a CSV file of date,habit,done rows.",
+			run:  runImportCSV,
+		},
+		"import-loop": {
+			args: "[-db file] file",
+			desc: "Add habits and their history from a Loop Habit Tracker backup or CSV export (zip or directory).",
+			run:  runImportLoop,
+		},
+		"list": {
+			args:     "[-db file] [-date YYYY-MM-DD] [-json]",
+			desc:     "Show the habits for a day and whether each is done.",
+			run:      runList,
+			readOnly: true,
+		},
+		"restore": {
+			args:  "[-db file] [-list] [backup-file]",
+			desc:  "Replace the database with its newest backup (or the given one), keeping the replaced file.",
+			run:   runRestore,
+			local: true,
+		},
+		"serve": {
+			args:  "[-db file] [-addr host:port]",
+			desc:  "Serve a JSON API for reading and changing habits on localhost.",
+			run:   runServe,
+			local: true,
+		},
+		"status": {
+			args:     "[-db file] [-date YYYY-MM-DD] [-json]",
+			desc:     "Show how much of a day's habits are done.",
+			run:      runStatus,
+			readOnly: true,
+		},
+		"tui": {
+			args:  "[-db file]",
+			desc:  "Open a full-screen terminal interface instead of a window.",
+			run:   runTUI,
+			local: true,
+		},
+		"uncheck": {
+			args: "[-db file] [-date YYYY-MM-DD] [-json] habit",
+			desc: "Mark a habit as not done, by its ID or (the start of) its name.",
+			run:  runUncheck,
+		},
+	}
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: todaily [-print-frame-times] [db-file]\n")
+	fmt.Fprintf(os.Stderr, "       todaily <command> [arguments]\n\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	cmds := commands()
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n    \t%s\n", name, cmds[name].args, cmds[name].desc)
+	}
+}
+
+// errUsage is returned by a command that was given invalid arguments, after
+// its usage has been printed.
+var errUsage = errors.New("invalid usage")
+
+// cmdEnv is what a command runs with. Commands run from the command line
+// open the database themselves, while commands forwarded to a running window
+// use its store and send their output back.
+type cmdEnv struct {
+	stdout io.Writer
+	stderr io.Writer
+	// dir is the working directory that relative paths are relative to, if
+	// it's not this process's own.
+	dir string
+	// store is the already open store to use, if any.
+	store *store
+}
+
+// runCommand runs the named command, forwarding it to a window that has the
+// database open if there is one, and returns the exit code.
+func runCommand(name string, args []string) int {
+	cmd := commands()[name]
+	var err error
+	forwarded := false
+	if !cmd.local {
+		forwarded, err = forwardCommand(dbFileArg(args), append([]string{name}, args...))
+	}
+	if !forwarded {
+		err = cmd.run(&cmdEnv{stdout: os.Stdout, stderr: os.Stderr}, args)
+	}
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "todaily %s: %v\n", name, err)
+	return 1
+}
+
+// newCommandFlags returns the flag set for a subcommand along with the
+// `-db` flag that every subcommand has.
+func newCommandFlags(env *cmdEnv, name string) (*flag.FlagSet, *string) {
+	cmd := commands()[name]
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: todaily %s %s\n\n%s\n\n", name, cmd.args, cmd.desc)
+		fs.PrintDefaults()
+	}
+	dbFile := fs.String("db", "", "The database file (defaults to ~/.todaily/db.todaily).")
+	return fs, dbFile
+}
+
+// parseFlags parses a command's arguments. The flag set has already printed
+// any problem by the time an error is returned.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// usageError prints a command's usage and returns `errUsage`.
+func usageError(fs *flag.FlagSet) error {
+	fs.Usage()
+	return errUsage
+}
+
+// dbFileArg returns the value of the `-db` flag in a command's arguments
+// without parsing the rest of them.
+func dbFileArg(args []string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return ""
+		case arg == "-db" || arg == "--db":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+		case strings.HasPrefix(arg, "-db=") || strings.HasPrefix(arg, "--db="):
+			return arg[strings.Index(arg, "=")+1:]
+		}
+	}
+	return ""
+}
+
+// path returns the given file path relative to the command's working directory.
+func (env *cmdEnv) path(fpath string) string {
+	if env.dir == "" || fpath == "" || filepath.IsAbs(fpath) {
+		return fpath
+	}
+	return filepath.Join(env.dir, fpath)
+}
+
+// withStore runs `fn` with the command's store, opening the one at the given
+// path if it doesn't already have one.
+func (env *cmdEnv) withStore(dbFile string, fn func(s *store) error) error {
+	if env.store != nil {
+		return fn(env.store)
+	}
+	return withStore(env.path(dbFile), fn)
+}
+
+// withStore opens the store at the given path for the duration of `fn`,
+// asking for its passphrase on the terminal if it's encrypted.
+func withStore(dbFile string, fn func(s *store) error) error {
+	s, err := openStore(dbFile, terminalPassphrase)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return fn(s)
+}
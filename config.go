@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// setting is a preference kept in the database that `todaily config` can
+// show and change.
+type setting struct {
+	desc string
+	get  func(s *store) (string, error)
+	set  func(s *store, value string) error
+}
+
+// settings returns all settings by name.
+func settings() map[string]setting {
+	return map[string]setting{
+		"day-start": {
+			desc: "How long after midnight each day begins (e.g. 2h30m). Habits done before then count for the day before.",
+			get: func(s *store) (string, error) {
+				return getDayStart().String(), nil
+			},
+			set: func(s *store, value string) error {
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("invalid duration %q, expected something like 2h or 90m", value)
+				}
+				return s.setDayStart(d)
+			},
+		},
+		"timezone": {
+			desc: "The home time zone that days begin and end in (e.g. America/New_York), or local to follow the system's.",
+			get: func(s *store) (string, error) {
+				cs, err := s.getClockSettings()
+				if cs.homeZone == "" {
+					return "local", err
+				}
+				return cs.homeZone, err
+			},
+			set: func(s *store, value string) error {
+				if value == "local" {
+					value = ""
+				}
+				return s.setHomeZone(value)
+			},
+		},
+		"travel": {
+			desc: "A time zone to use instead of the home one while traveling, or off.",
+			get: func(s *store) (string, error) {
+				cs, err := s.getClockSettings()
+				if cs.travelZone == "" {
+					return "off", err
+				}
+				return cs.travelZone, err
+			},
+			set: func(s *store, value string) error {
+				if value == "off" {
+					value = ""
+				}
+				return s.setTravelZone(value)
+			},
+		},
+	}
+}
+
+func runConfig(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "config")
+	asJSON := fs.Bool("json", false, "Write the settings as JSON.")
+	rest, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 2 {
+		return usageError(fs)
+	}
+	all := settings()
+	names := make([]string, 0, len(all))
+	if len(rest) > 0 {
+		if _, ok := all[rest[0]]; !ok {
+			return fmt.Errorf("unknown setting %q", rest[0])
+		}
+		names = append(names, rest[0])
+	} else {
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		if len(rest) == 2 {
+			if err := all[rest[0]].set(s, rest[1]); err != nil {
+				return err
+			}
+		}
+		values := make(map[string]string, len(names))
+		var text string
+		for _, name := range names {
+			v, err := all[name].get(s)
+			if err != nil {
+				return err
+			}
+			values[name] = v
+			text += fmt.Sprintf("%s = %s\n", name, v)
+			if len(rest) == 0 {
+				text += "    " + all[name].desc + "\n"
+			}
+		}
+		return output{env.stdout, *asJSON}.write(values, text)
+	})
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"gioui.org/layout"
+	"gioui.org/text"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// A database can be encrypted with a passphrase. Every value that `put` writes
+// is then sealed with AES-256-GCM using a key derived from the passphrase with
+// scrypt, and `get` opens it again. Keys (which are dates) and bucket names
+// aren't encrypted, but each value is sealed along with its bucket name and
+// key so that it can't be moved under another one without that being noticed.
+// The schema version and the `cryptHeader` kept under meta's "crypt" key aren't
+// encrypted either, since they're needed before the database can be unlocked.
+
+// cryptHeader describes how a database's key is derived from its passphrase.
+type cryptHeader struct {
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	// Check is `cryptCheckText` sealed with the key, to tell whether a
+	// passphrase is the right one.
+	Check []byte `json:"check"`
+	// Bound is set once values are sealed along with their bucket and key.
+	// The values of a database encrypted before then are sealed with no
+	// additional data, and are sealed again when it's next unlocked.
+	Bound bool `json:"bound,omitempty"`
+}
+
+const cryptCheckText = "todaily"
+
+// cryptCheckAD is what `Check` is sealed with, as if it were a value under
+// meta's "crypt" key.
+var cryptCheckAD = valueAD("meta", []byte("crypt"))
+
+// errNoPassphrase is returned when a passphrase is needed but there's no
+// terminal to ask for it on.
+var errNoPassphrase = errors.New("a passphrase is needed, run in a terminal or set TODAILY_PASSPHRASE")
+
+// errStoreEncrypted is returned when opening an encrypted database either
+// without any way to ask for its passphrase or with the wrong one.
+type errStoreEncrypted struct {
+	fpath string
+	wrong bool
+}
+
+func (e errStoreEncrypted) Error() string {
+	if e.wrong {
+		return fmt.Sprintf("wrong passphrase for %s", e.fpath)
+	}
+	return fmt.Sprintf("%s is encrypted", e.fpath)
+}
+
+// valueCipher seals and opens the values of an encrypted database.
+type valueCipher struct {
+	aead cipher.AEAD
+	// unbound is set to seal and open values with no additional data, the way
+	// they were before being bound to their bucket and key.
+	unbound bool
+}
+
+// ciphers holds the `*valueCipher` of each open encrypted database by its
+// `*bbolt.DB`, which is how `put` and `get` find it from a bucket.
+var ciphers sync.Map
+
+func cipherOf(b bucket) *valueCipher {
+	if c, ok := ciphers.Load(b.Tx().DB()); ok {
+		return c.(*valueCipher)
+	}
+	return nil
+}
+
+func newCryptHeader(passphrase []byte) (cryptHeader, *valueCipher, error) {
+	h := cryptHeader{Salt: make([]byte, 16), N: 1 << 15, R: 8, P: 1, Bound: true}
+	if _, err := rand.Read(h.Salt); err != nil {
+		return cryptHeader{}, nil, fmt.Errorf("generating salt: %w", err)
+	}
+	c, err := h.deriveCipher(passphrase)
+	if err != nil {
+		return cryptHeader{}, nil, err
+	}
+	h.Check, err = c.seal([]byte(cryptCheckText), cryptCheckAD)
+	if err != nil {
+		return cryptHeader{}, nil, err
+	}
+	return h, c, nil
+}
+
+func (h *cryptHeader) deriveCipher(passphrase []byte) (*valueCipher, error) {
+	key, err := scrypt.Key(passphrase, h.Salt, h.N, h.R, h.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &valueCipher{aead: aead}, nil
+}
+
+// lastUnlocked is the cipher of the last database that was unlocked along
+// with the passphrase and header check it was unlocked with. Deriving a key
+// is slow on purpose, and `todaily serve` opens the database for every
+// request.
+var lastUnlocked struct {
+	sync.Mutex
+	check      []byte
+	passphrase []byte
+	c          *valueCipher
+}
+
+// unlock returns the cipher for the given passphrase, or nil if it's the
+// wrong one.
+func (h *cryptHeader) unlock(passphrase []byte) (*valueCipher, error) {
+	lastUnlocked.Lock()
+	defer lastUnlocked.Unlock()
+	if lastUnlocked.c != nil && bytes.Equal(lastUnlocked.check, h.Check) && bytes.Equal(lastUnlocked.passphrase, passphrase) {
+		return lastUnlocked.c, nil
+	}
+	c, err := h.deriveCipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	checker := c
+	if !h.Bound {
+		checker = c.withoutAD()
+	}
+	check, err := checker.open(h.Check, cryptCheckAD)
+	if err != nil || !bytes.Equal(check, []byte(cryptCheckText)) {
+		return nil, nil
+	}
+	lastUnlocked.check = append([]byte(nil), h.Check...)
+	lastUnlocked.passphrase = append([]byte(nil), passphrase...)
+	lastUnlocked.c = c
+	return c, nil
+}
+
+// unlockDB registers the cipher of the given database if it's encrypted,
+// asking for its passphrase with `passphrase`.
+func unlockDB(db *bbolt.DB, fpath string, passphrase func(fpath string) ([]byte, error)) error {
+	header, err := readCryptHeader(db)
+	if err != nil {
+		return fmt.Errorf("reading encryption header: %w", err)
+	}
+	if header == nil {
+		return nil
+	}
+	if passphrase == nil {
+		return errStoreEncrypted{fpath: fpath}
+	}
+	p, err := passphrase(fpath)
+	if err != nil {
+		return err
+	}
+	c, err := header.unlock(p)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		return errStoreEncrypted{fpath: fpath, wrong: true}
+	}
+	if !header.Bound {
+		if err := bindValues(db, header, c); err != nil {
+			return fmt.Errorf("sealing values with their keys: %w", err)
+		}
+	}
+	ciphers.Store(db, c)
+	return nil
+}
+
+// bindValues seals each value of a database that was encrypted before values
+// were bound to their bucket and key again, this time along with them.
+func bindValues(db *bbolt.DB, header *cryptHeader, c *valueCipher) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		if err := recodeValues(tx, c.withoutAD(), c); err != nil {
+			return err
+		}
+		check, err := c.seal([]byte(cryptCheckText), cryptCheckAD)
+		if err != nil {
+			return err
+		}
+		bound := *header
+		bound.Check = check
+		bound.Bound = true
+		return putPlain(bucketOf(tx, "meta"), []byte("crypt"), bound)
+	})
+}
+
+// cipher returns the store's cipher, or nil if it isn't encrypted.
+func (s *store) cipher() *valueCipher {
+	if c, ok := ciphers.Load(s.db); ok {
+		return c.(*valueCipher)
+	}
+	return nil
+}
+
+// valueAD returns the additional data that the value under the given key in
+// the given bucket is sealed with.
+func valueAD(bucket string, key []byte) []byte {
+	return append(append([]byte(bucket), 0), key...)
+}
+
+// withoutAD returns a copy of the cipher that ignores additional data.
+func (c *valueCipher) withoutAD() *valueCipher {
+	return &valueCipher{aead: c.aead, unbound: true}
+}
+
+// seal encrypts `plain` along with the additional data `ad`, returning the
+// nonce followed by the ciphertext.
+func (c *valueCipher) seal(plain, ad []byte) ([]byte, error) {
+	if c.unbound {
+		ad = nil
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plain)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plain, ad), nil
+}
+
+// open decrypts a value sealed by `seal`, which fails unless `ad` is the same.
+func (c *valueCipher) open(data, ad []byte) ([]byte, error) {
+	if c.unbound {
+		ad = nil
+	}
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return nil, errors.New("encrypted value is too short")
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], ad)
+	if err != nil {
+		return nil, errors.New("encrypted value can't be opened")
+	}
+	return plain, nil
+}
+
+// readCryptHeader returns the database's crypt header, or nil if it isn't
+// encrypted.
+func readCryptHeader(db *bbolt.DB) (header *cryptHeader, _ error) {
+	return header, db.View(func(tx *bbolt.Tx) error {
+		meta := bucketOf(tx, "meta")
+		if meta.Bucket == nil || meta.Get([]byte("crypt")) == nil {
+			return nil
+		}
+		header = &cryptHeader{}
+		return getPlain(meta, []byte("crypt"), header)
+	})
+}
+
+// recodeValues re-encodes every value in the database (other than the
+// unencrypted ones in meta) from the `from` cipher to the `to` cipher, where
+// nil means unencrypted.
+func recodeValues(tx *bbolt.Tx, from, to *valueCipher) error {
+	return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		type kv struct{ k, v []byte }
+		var values []kv
+		if err := b.ForEach(func(k, v []byte) error {
+			if v == nil || (string(name) == "meta" && isPlainMetaKey(k)) {
+				return nil
+			}
+			values = append(values, kv{append([]byte(nil), k...), append([]byte(nil), v...)})
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, e := range values {
+			data := e.v
+			if from != nil {
+				plain, err := from.open(data, valueAD(string(name), e.k))
+				if err != nil {
+					return fmt.Errorf("decrypting %s %q: %w", name, e.k, err)
+				}
+				data = plain
+			}
+			if to != nil {
+				sealed, err := to.seal(data, valueAD(string(name), e.k))
+				if err != nil {
+					return err
+				}
+				data = sealed
+			}
+			if err := b.Put(e.k, data); err != nil {
+				return fmt.Errorf("writing %s %q: %w", name, e.k, err)
+			}
+		}
+		return nil
+	})
+}
+
+func isPlainMetaKey(k []byte) bool {
+	return string(k) == "schemaVersion" || string(k) == "crypt"
+}
+
+// compactFile rewrites the database at `fpath` so it holds none of the
+// pages that were freed (which may still hold old values).
+func compactFile(fpath string) error {
+	src, err := bbolt.Open(fpath, 0o644, &bbolt.Options{Timeout: lockTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := fpath + ".compacting"
+	os.Remove(tmp)
+	dst, err := bbolt.Open(tmp, 0o644, nil)
+	if err != nil {
+		return err
+	}
+	if err := bbolt.Compact(dst, src, 0); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	src.Close()
+	return os.Rename(tmp, fpath)
+}
+
+// terminalPassphrase asks for a database's passphrase on the terminal, or
+// takes it from the `TODAILY_PASSPHRASE` environment variable if it's set.
+func terminalPassphrase(fpath string) ([]byte, error) {
+	if p, ok := os.LookupEnv("TODAILY_PASSPHRASE"); ok {
+		return []byte(p), nil
+	}
+	return readPassphrase("Passphrase for " + fpath + ": ")
+}
+
+func readPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errNoPassphrase
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("reading passphrase: %w", err)
+	}
+	return p, nil
+}
+
+// readNewPassphrase asks for a new passphrase twice on the terminal, unless
+// it's set in `TODAILY_PASSPHRASE`.
+func readNewPassphrase() ([]byte, error) {
+	if p, ok := os.LookupEnv("TODAILY_PASSPHRASE"); ok {
+		return []byte(p), nil
+	}
+	p, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, errors.New("the passphrase can't be empty")
+	}
+	again, err := readPassphrase("Repeat the passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p, again) {
+		return nil, errors.New("the passphrases don't match")
+	}
+	return p, nil
+}
+
+func runEncrypt(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "encrypt")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	dbPath, err := resolveDBPath(env.path(*dbFile))
+	if err != nil {
+		return err
+	}
+	s, err := openStore(dbPath, nil)
+	var encrypted errStoreEncrypted
+	if errors.As(err, &encrypted) {
+		return errors.New("the database is already encrypted")
+	}
+	if err != nil {
+		return err
+	}
+	passphrase, err := readNewPassphrase()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	header, c, err := newCryptHeader(passphrase)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		if err := recodeValues(tx, nil, c); err != nil {
+			return err
+		}
+		return putPlain(bucketOf(tx, "meta"), []byte("crypt"), header)
+	})
+	s.Close()
+	if err != nil {
+		return fmt.Errorf("encrypting: %w", err)
+	}
+	if err := compactFile(dbPath); err != nil {
+		return fmt.Errorf("compacting: %w", err)
+	}
+	fmt.Fprintln(env.stdout, "Encrypted "+dbPath)
+	warnPlainBackups(env, dbPath)
+	return nil
+}
+
+func runDecrypt(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "decrypt")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	s, err := openStore(env.path(*dbFile), terminalPassphrase)
+	if err != nil {
+		return err
+	}
+	c := s.cipher()
+	if c == nil {
+		s.Close()
+		return errors.New("the database isn't encrypted")
+	}
+	dbPath := s.db.Path()
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		if err := recodeValues(tx, c, nil); err != nil {
+			return err
+		}
+		return bucketOf(tx, "meta").Delete([]byte("crypt"))
+	})
+	s.Close()
+	if err != nil {
+		return fmt.Errorf("decrypting: %w", err)
+	}
+	fmt.Fprintln(env.stdout, "Decrypted "+dbPath)
+	return nil
+}
+
+// warnPlainBackups points out any backups that were taken before the
+// database was encrypted, since they aren't.
+func warnPlainBackups(env *cmdEnv, dbPath string) {
+	backups, err := listBackups(dbPath)
+	if err != nil || len(backups) == 0 {
+		return
+	}
+	fmt.Fprintf(env.stdout, "\nThe %d backups in %s were taken before encrypting and aren't encrypted. "+
+		"Delete them if they shouldn't stay readable, along with any %s.v*.bak files.\n", len(backups), backupDir(dbPath), dbPath)
+}
+
+// unlockSplash is shown in place of the home screen when the database is
+// encrypted, asking for its passphrase.
+type unlockSplash struct {
+	updates    chan<- any
+	fpath      string
+	passphrase widget.Editor
+	unlock     widget.Clickable
+	// wrong is set once a passphrase has been tried and was wrong.
+	wrong bool
+	busy  bool
+}
+
+func (us *unlockSplash) layout(gtx C, th *material.Theme) D {
+	submitted := us.unlock.Clicked()
+	for _, e := range us.passphrase.Events() {
+		if _, ok := e.(widget.SubmitEvent); ok {
+			submitted = true
+		}
+	}
+	if submitted && !us.busy && us.passphrase.Text() != "" {
+		us.busy = true
+		p := []byte(us.passphrase.Text())
+		us.passphrase.SetText("")
+		go initLoad(us.fpath, p, us.updates)
+	}
+
+	msg := us.fpath + " is encrypted. Enter its passphrase to open it."
+	if us.wrong {
+		msg = "That passphrase isn't right. Try again."
+	}
+	return layout.Center.Layout(gtx, func(gtx C) D {
+		gtx.Constraints.Max.X = 460
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(material.H6(th, "Todaily's database is locked").Layout),
+			layout.Rigid(layout.Spacer{Height: 12}.Layout),
+			layout.Rigid(func(gtx C) D {
+				lbl := material.Body1(th, msg)
+				lbl.Alignment = text.Middle
+				return lbl.Layout(gtx)
+			}),
+			layout.Rigid(layout.Spacer{Height: 20}.Layout),
+			layout.Rigid(editor{th: th, state: &us.passphrase, hint: "Passphrase"}.layout),
+			layout.Rigid(layout.Spacer{Height: 20}.Layout),
+			layout.Rigid(func(gtx C) D {
+				label := "Unlock"
+				if us.busy {
+					label = "Unlocking..."
+				}
+				return material.Button(th, &us.unlock, label).Layout(gtx)
+			}),
+		)
+	})
+}
+
+// splashEncrypted is sent instead of a `splashErr` when the database is
+// encrypted and its passphrase is needed.
+type splashEncrypted struct {
+	err errStoreEncrypted
+}
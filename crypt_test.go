@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestUnlockUnboundValues(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "db.todaily")
+	s, err := openStore(fpath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.putHabits([]habit{{ID: 1, Content: "Read"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Encrypt it the way it was before values were bound to their keys.
+	passphrase := []byte("secret")
+	header, c, err := newCryptHeader(passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bbolt.Open(fpath, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if err := recodeValues(tx, nil, c.withoutAD()); err != nil {
+			return err
+		}
+		header.Bound = false
+		if header.Check, err = c.withoutAD().seal([]byte(cryptCheckText), nil); err != nil {
+			return err
+		}
+		return putPlain(bucketOf(tx, "meta"), []byte("crypt"), header)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		s, err := openStore(fpath, func(string) ([]byte, error) { return passphrase, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		habits, err := s.getHabits()
+		if err != nil {
+			s.Close()
+			t.Fatal(err)
+		}
+		if len(habits) != 1 || habits[0].Content != "Read" {
+			t.Errorf("got habits %+v, want just \"Read\"", habits)
+		}
+		h, err := readCryptHeader(s.db)
+		s.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.Bound {
+			t.Error("the values weren't sealed again with their keys")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var csvHeader = []string{"date", "habit_id", "habit", "completed", "completed_at"}
+
+// writeCSV writes one row per habit per day for the days from `from` through
+// `to` (both formatted dates, either of which can be empty for no limit).
+func (s *store) writeCSV(w io.Writer, from, to string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	if err := s.db.View(func(tx *bbolt.Tx) error {
+		dailys := bucketOf(tx, "dailyRecords")
+		return forEachInRange(dailys, from, to, func(k, v []byte) error {
+			fmtDate := string(k)
+			t, err := parseKey(fmtDate)
+			if err != nil {
+				return fmt.Errorf("parsing record key %q: %w", fmtDate, err)
+			}
+			var items []habit
+			if err := decode(dailys, k, v, &items); err != nil {
+				return fmt.Errorf("decoding habits for %q: %w", fmtDate, err)
+			}
+			for _, h := range items {
+				var completedAt string
+				if h.isDone() {
+					completedAt = h.CompletedAt.Format(time.RFC3339)
+				}
+				if err := cw.Write([]string{
+					t.Format("2006-01-02"),
+					strconv.Itoa(h.ID),
+					h.Content,
+					strconv.FormatBool(h.isDone()),
+					completedAt,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportCSVFile writes the days from `from` through `to` to a new CSV file in
+// an `exports` directory beside the database and returns the file's path.
+func (s *store) exportCSVFile(from, to string) (string, error) {
+	dir := filepath.Join(filepath.Dir(s.db.Path()), "exports")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	fpath := filepath.Join(dir, "todaily-"+time.Now().Format("2006-01-02-150405")+".csv")
+	f, err := os.Create(fpath)
+	if err != nil {
+		return "", err
+	}
+	if err := s.writeCSV(f, from, to); err != nil {
+		f.Close()
+		return "", err
+	}
+	return fpath, f.Close()
+}
+
+func runCSV(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "csv")
+	fromDate := fs.String("from", "", "The first day to include, as YYYY-MM-DD.")
+	toDate := fs.String("to", "", "The last day to include, as YYYY-MM-DD.")
+	outFile := fs.String("o", "", "The file to write to (defaults to stdout).")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var from, to string
+	for _, d := range []struct {
+		flag string
+		dst  *string
+	}{{*fromDate, &from}, {*toDate, &to}} {
+		if d.flag == "" {
+			continue
+		}
+		t, err := parseDate(d.flag)
+		if err != nil {
+			return fmt.Errorf("invalid date %q: %w", d.flag, err)
+		}
+		*d.dst = t.Format(keyFormat)
+	}
+
+	return env.withStore(*dbFile, func(s *store) error {
+		if *outFile == "" {
+			return s.writeCSV(env.stdout, from, to)
+		}
+		// The file is only created once the store is open, so that an existing
+		// one isn't emptied when the database can't be read.
+		f, err := os.Create(env.path(*outFile))
+		if err != nil {
+			return err
+		}
+		if err := s.writeCSV(f, from, to); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	// Time zones can be set by name even where the system has no zone database.
+	_ "time/tzdata"
+
+	"go.etcd.io/bbolt"
+)
+
+// Every date in the database (such as the keys of the daily records) is the
+// day in `keyFormat`, and which day a moment counts toward is decided by the
+// database's `dayClock` rather than by wherever the computer happens to be.
+// Dates should only be derived through the functions in this file.
+
+const (
+	keyFormat = "20060102"
+
+	// maxDayStart is the latest a day can be set to start after midnight.
+	maxDayStart = 12 * time.Hour
+)
+
+// dayClock decides which day each moment counts toward.
+type dayClock struct {
+	// zone is the time zone that days begin and end in: the travel time zone
+	// if one is set, otherwise the home time zone, otherwise the system's.
+	zone *time.Location
+	// start is how long after midnight each day begins, so that habits done
+	// late at night can still count for the day before.
+	start time.Duration
+}
+
+// clock is the clock of the open database. It's loaded whenever a database
+// is opened (see `loadClock`).
+var clock atomic.Pointer[dayClock]
+
+func currentClock() *dayClock {
+	if c := clock.Load(); c != nil {
+		return c
+	}
+	return &dayClock{zone: time.Local}
+}
+
+func getDayStart() time.Duration {
+	return currentClock().start
+}
+
+// dayOf returns midnight at the start of the day that the moment `t` counts
+// toward, in the day zone.
+func dayOf(t time.Time) time.Time {
+	c := currentClock()
+	t = t.In(c.zone)
+	y, m, d := t.Date()
+	if t.Before(c.startOf(y, m, d)) {
+		d--
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, c.zone)
+}
+
+// startOf returns the moment the given day begins. The day start is by the
+// wall clock, so it stays put on days when daylight saving time changes.
+func (c *dayClock) startOf(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, int(c.start), c.zone)
+}
+
+// startOfDay returns the moment that the day starting at midnight `day` (as
+// returned by `dayOf` or `parseKey`) begins.
+func startOfDay(day time.Time) time.Time {
+	y, m, d := day.Date()
+	return currentClock().startOf(y, m, d)
+}
+
+// todayKey returns the database key of the day it is now.
+func todayKey() string {
+	return dayOf(time.Now()).Format(keyFormat)
+}
+
+// nextDayStart returns the moment the day after the one `t` counts toward
+// begins.
+func nextDayStart(t time.Time) time.Time {
+	y, m, d := dayOf(t).Date()
+	return currentClock().startOf(y, m, d+1)
+}
+
+// parseKey returns midnight at the start of the day with the given database
+// key.
+func parseKey(k string) (time.Time, error) {
+	return time.ParseInLocation(keyFormat, k, currentClock().zone)
+}
+
+// parseDate returns midnight at the start of a day written as YYYY-MM-DD.
+func parseDate(date string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", date, currentClock().zone)
+}
+
+// clockSettings are the settings kept in meta that make up the `dayClock`.
+type clockSettings struct {
+	// dayStart is in minutes.
+	dayStart   int
+	homeZone   string
+	travelZone string
+}
+
+func readClockSettings(tx *bbolt.Tx) (cs clockSettings, _ error) {
+	meta := bucketOf(tx, "meta")
+	if err := get(meta, []byte("dayStart"), &cs.dayStart); err != nil {
+		return cs, fmt.Errorf("reading day start: %w", err)
+	}
+	if err := get(meta, []byte("timezone"), &cs.homeZone); err != nil {
+		return cs, fmt.Errorf("reading time zone: %w", err)
+	}
+	if err := get(meta, []byte("travelTimezone"), &cs.travelZone); err != nil {
+		return cs, fmt.Errorf("reading travel time zone: %w", err)
+	}
+	return cs, nil
+}
+
+func (cs clockSettings) clock() (*dayClock, error) {
+	c := dayClock{zone: time.Local, start: time.Duration(cs.dayStart) * time.Minute}
+	name := cs.travelZone
+	if name == "" {
+		name = cs.homeZone
+	}
+	if name != "" {
+		zone, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("loading time zone %q: %w", name, err)
+		}
+		c.zone = zone
+	}
+	return &c, nil
+}
+
+// loadClock sets `clock` from the database's settings.
+func (s *store) loadClock() error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		cs, err := readClockSettings(tx)
+		if err != nil {
+			return err
+		}
+		c, err := cs.clock()
+		if err != nil {
+			return err
+		}
+		clock.Store(c)
+		return nil
+	})
+}
+
+// updateClock changes the database's clock settings with `fn` and then
+// reloads `clock`.
+func (s *store) updateClock(fn func(cs *clockSettings)) error {
+	if err := s.db.Update(func(tx *bbolt.Tx) error {
+		cs, err := readClockSettings(tx)
+		if err != nil {
+			return err
+		}
+		fn(&cs)
+		if _, err := cs.clock(); err != nil {
+			return err
+		}
+		meta := bucketOf(tx, "meta")
+		if err := put(meta, []byte("dayStart"), cs.dayStart); err != nil {
+			return err
+		}
+		if err := put(meta, []byte("timezone"), cs.homeZone); err != nil {
+			return err
+		}
+		return put(meta, []byte("travelTimezone"), cs.travelZone)
+	}); err != nil {
+		return fmt.Errorf("saving settings: %w", err)
+	}
+	return s.loadClock()
+}
+
+// setDayStart saves how long after midnight each day begins.
+func (s *store) setDayStart(d time.Duration) error {
+	if d < 0 || d > maxDayStart {
+		return fmt.Errorf("a day can start between midnight and %v after it", maxDayStart)
+	}
+	return s.updateClock(func(cs *clockSettings) {
+		cs.dayStart = int(d / time.Minute)
+	})
+}
+
+// setHomeZone saves the time zone that days begin and end in, where an
+// empty name means the system's.
+func (s *store) setHomeZone(name string) error {
+	return s.updateClock(func(cs *clockSettings) {
+		cs.homeZone = name
+	})
+}
+
+// setTravelZone saves a time zone to use instead of the home one while
+// traveling, where an empty name ends the trip.
+func (s *store) setTravelZone(name string) error {
+	return s.updateClock(func(cs *clockSettings) {
+		cs.travelZone = name
+	})
+}
+
+// getClockSettings returns the database's clock settings.
+func (s *store) getClockSettings() (cs clockSettings, _ error) {
+	return cs, s.db.View(func(tx *bbolt.Tx) (err error) {
+		cs, err = readClockSettings(tx)
+		return err
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// useClock sets the clock to the given zone and day start for the rest of
+// the test and returns the zone.
+func useClock(t *testing.T, zone string, start time.Duration) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := clock.Load()
+	clock.Store(&dayClock{zone: loc, start: start})
+	t.Cleanup(func() { clock.Store(prev) })
+	return loc
+}
+
+func TestDayOf(t *testing.T) {
+	tests := []struct {
+		zone  string
+		start time.Duration
+		// at is a moment in UTC.
+		at   string
+		want string
+	}{
+		{"UTC", 0, "2026-06-01 00:00", "20260601"},
+		{"UTC", 0, "2026-05-31 23:59", "20260531"},
+		{"America/New_York", 0, "2026-06-01 02:00", "20260531"},
+		{"America/New_York", 0, "2026-06-01 04:00", "20260601"},
+		{"Asia/Tokyo", 0, "2026-05-31 15:00", "20260601"},
+		{"Asia/Kolkata", 0, "2026-05-31 18:29", "20260531"},
+		{"Asia/Kolkata", 0, "2026-05-31 18:30", "20260601"},
+
+		// 04:00 and 03:59 in New York during daylight saving time.
+		{"America/New_York", 4 * time.Hour, "2026-06-10 08:00", "20260610"},
+		{"America/New_York", 4 * time.Hour, "2026-06-10 07:59", "20260609"},
+		{"Asia/Tokyo", 4 * time.Hour, "2026-06-09 18:59", "20260609"},
+		{"Asia/Tokyo", 4 * time.Hour, "2026-06-09 19:00", "20260610"},
+		{"UTC", 90 * time.Minute, "2026-01-01 01:29", "20251231"},
+		{"UTC", 90 * time.Minute, "2026-01-01 01:30", "20260101"},
+		{"UTC", maxDayStart, "2026-01-01 11:59", "20251231"},
+		{"UTC", maxDayStart, "2026-01-01 12:00", "20260101"},
+
+		// Clocks in New York go forward from 02:00 to 03:00 on March 8th, so
+		// the day begins at 04:00 EDT rather than 04:00 EST.
+		{"America/New_York", 0, "2026-03-08 04:59", "20260307"},
+		{"America/New_York", 0, "2026-03-08 05:00", "20260308"},
+		{"America/New_York", 4 * time.Hour, "2026-03-08 07:59", "20260307"},
+		{"America/New_York", 4 * time.Hour, "2026-03-08 08:00", "20260308"},
+		{"America/New_York", 4 * time.Hour, "2026-03-09 07:59", "20260308"},
+		{"America/New_York", 4 * time.Hour, "2026-03-09 08:00", "20260309"},
+		// They go back from 02:00 to 01:00 on November 1st, so the day begins
+		// at 04:00 EST rather than 04:00 EDT.
+		{"America/New_York", 4 * time.Hour, "2026-11-01 08:59", "20261031"},
+		{"America/New_York", 4 * time.Hour, "2026-11-01 09:00", "20261101"},
+		{"America/New_York", 4 * time.Hour, "2026-10-31 07:59", "20261030"},
+		{"America/New_York", 4 * time.Hour, "2026-10-31 08:00", "20261031"},
+		{"America/New_York", 0, "2026-11-01 04:59", "20261101"},
+		{"America/New_York", 0, "2026-11-02 04:59", "20261101"},
+		{"America/New_York", 0, "2026-11-02 05:00", "20261102"},
+		// Europe changes at 01:00 UTC on the last Sunday of March and October.
+		{"Europe/Berlin", 2 * time.Hour, "2026-03-29 00:59", "20260328"},
+		{"Europe/Berlin", 2 * time.Hour, "2026-03-29 01:00", "20260329"},
+		{"Europe/Berlin", 2 * time.Hour, "2026-03-29 23:59", "20260329"},
+		{"Europe/Berlin", 2 * time.Hour, "2026-10-24 23:59", "20261024"},
+		{"Europe/Berlin", 2 * time.Hour, "2026-10-25 01:00", "20261025"},
+		{"Europe/Berlin", 2 * time.Hour, "2026-10-25 23:59", "20261025"},
+	}
+	for _, tt := range tests {
+		loc := useClock(t, tt.zone, tt.start)
+		at, err := time.Parse("2006-01-02 15:04", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		day := dayOf(at)
+		if got := day.Format(keyFormat); got != tt.want {
+			t.Errorf("dayOf(%s UTC) in %s starting at %v = %s, want %s", tt.at, tt.zone, tt.start, got, tt.want)
+		}
+		if day.Location() != loc || day.Hour() != 0 || day.Minute() != 0 {
+			t.Errorf("dayOf(%s UTC) in %s = %v, want midnight in %[2]s", tt.at, tt.zone, day)
+		}
+	}
+}
+
+func TestNextDayStart(t *testing.T) {
+	tests := []struct {
+		zone  string
+		start time.Duration
+		at    string
+		// want is a wall clock time in the zone.
+		want string
+	}{
+		{"UTC", 0, "2026-06-01 12:00", "2026-06-02 00:00"},
+		{"UTC", 4 * time.Hour, "2026-06-01 03:00", "2026-06-01 04:00"},
+		{"UTC", 4 * time.Hour, "2026-06-01 04:00", "2026-06-02 04:00"},
+		{"America/New_York", 4 * time.Hour, "2026-03-07 12:00", "2026-03-08 04:00"},
+		{"America/New_York", 4 * time.Hour, "2026-03-08 12:00", "2026-03-09 04:00"},
+		{"America/New_York", 4 * time.Hour, "2026-10-31 12:00", "2026-11-01 04:00"},
+		{"America/New_York", 0, "2026-11-01 12:00", "2026-11-02 00:00"},
+		{"Asia/Kolkata", 30 * time.Minute, "2026-05-31 18:45", "2026-06-01 00:30"},
+	}
+	for _, tt := range tests {
+		loc := useClock(t, tt.zone, tt.start)
+		at, err := time.Parse("2006-01-02 15:04", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := time.ParseInLocation("2006-01-02 15:04", tt.want, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := nextDayStart(at)
+		if !got.Equal(want) {
+			t.Errorf("nextDayStart(%s UTC) in %s starting at %v = %v, want %v", tt.at, tt.zone, tt.start, got, want)
+		}
+		// The new day should begin exactly then.
+		if !dayOf(got).After(dayOf(at)) || !dayOf(got.Add(-time.Second)).Equal(dayOf(at)) {
+			t.Errorf("the day after %s UTC in %s doesn't begin at %v", tt.at, tt.zone, got)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, zone := range []string{"UTC", "America/New_York", "Australia/Lord_Howe"} {
+		loc := useClock(t, zone, 2*time.Hour)
+		for _, k := range []string{"19991231", "20000101", "20240229", "20260308", "20261101", "20991231"} {
+			day, err := parseKey(k)
+			if err != nil {
+				t.Fatalf("parseKey(%q) in %s: %v", k, zone, err)
+			}
+			if got := day.Format(keyFormat); got != k {
+				t.Errorf("parseKey(%q) in %s formats back as %q", k, zone, got)
+			}
+			if day.Location() != loc || day.Hour() != 0 {
+				t.Errorf("parseKey(%q) in %s = %v, want midnight in %[2]s", k, zone, day)
+			}
+			date := day.Format("2006-01-02")
+			day2, err := parseDate(date)
+			if err != nil {
+				t.Fatalf("parseDate(%q) in %s: %v", date, zone, err)
+			}
+			if !day2.Equal(day) {
+				t.Errorf("parseDate(%q) in %s = %v, want %v", date, zone, day2, day)
+			}
+			// The day's own start counts toward it.
+			if got := dayOf(startOfDay(day)).Format(keyFormat); got != k {
+				t.Errorf("the start of %s in %s counts toward %s", k, zone, got)
+			}
+		}
+	}
+	for _, bad := range []string{"", "260308", "2026-03-08", "20261301", "20260230"} {
+		if _, err := parseKey(bad); err == nil {
+			t.Errorf("parseKey(%q) succeeded, want an error", bad)
+		}
+	}
+	for _, bad := range []string{"", "20260308", "2026-3-8", "2026-02-30"} {
+		if _, err := parseDate(bad); err == nil {
+			t.Errorf("parseDate(%q) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// The JSON export format holds everything in a database in one object:
+//
+//	{
+//	  "format": "todaily-export",
+//	  "version": 1,
+//	  "exported": "2026-10-15T21:04:05-04:00",
+//	  "habits": [ <habit>, ... ],
+//	  "days": [
+//	    {
+//	      "date": "2026-10-15",
+//	      "habits": [ <habit>, ... ],
+//	      "summary": { "completed": 2, "percent": 0.5 }
+//	    },
+//	    ...
+//	  ]
+//	}
+//
+// The top level "habits" list is the habit template list and each day's
+// "habits" list is that day's record. Days are sorted by date. A <habit> is:
+//
+//	{
+//	  "id": 3,                              // unique among the template list
+//	  "content": "Read",
+//	  "position": 0,                        // order in the template list
+//	  "created": "2026-01-02T08:00:00Z",
+//	  "completed": "2026-10-15T20:00:00Z",  // omitted if not done
+//	  "deleted": "2026-10-15T20:00:00Z",    // omitted if not deleted
+//	  "schedule": {
+//	    "kind": "daily",                    // "daily", "weekdays", "interval" or "weekly"
+//	    "weekdays": ["Mon", "Wed"],         // for "weekdays"
+//	    "interval": 3,                      // for "interval": every N days
+//	    "perWeek": 2                        // for "weekly": N times a week
+//	  },
+//	  "target": 8,                          // omitted for habits that are simply checked off
+//	  "unit": "glasses",
+//	  "value": 5                            // the amount logged that day
+//	}
+//
+// Summaries are included for convenience only. They're recomputed from each
+// day's habits on import.
+const (
+	exportFormatName    = "todaily-export"
+	exportFormatVersion = 1
+)
+
+type exportFile struct {
+	Format   string        `json:"format"`
+	Version  int           `json:"version"`
+	Exported time.Time     `json:"exported"`
+	Habits   []exportHabit `json:"habits"`
+	Days     []exportDay   `json:"days"`
+}
+
+type exportDay struct {
+	Date    string        `json:"date"`
+	Habits  []exportHabit `json:"habits"`
+	Summary exportSummary `json:"summary"`
+}
+
+type exportSummary struct {
+	Completed int     `json:"completed"`
+	Percent   float32 `json:"percent"`
+}
+
+type exportHabit struct {
+	ID        int            `json:"id"`
+	Content   string         `json:"content"`
+	Position  int            `json:"position"`
+	Created   time.Time      `json:"created"`
+	Completed *time.Time     `json:"completed,omitempty"`
+	Deleted   *time.Time     `json:"deleted,omitempty"`
+	Schedule  exportSchedule `json:"schedule"`
+	Target    float64        `json:"target,omitempty"`
+	Unit      string         `json:"unit,omitempty"`
+	Value     float64        `json:"value,omitempty"`
+}
+
+type exportSchedule struct {
+	Kind     string   `json:"kind"`
+	Weekdays []string `json:"weekdays,omitempty"`
+	Interval int      `json:"interval,omitempty"`
+	PerWeek  int      `json:"perWeek,omitempty"`
+}
+
+var scheduleKindNames = map[scheduleKind]string{
+	scheduleDaily:    "daily",
+	scheduleWeekdays: "weekdays",
+	scheduleInterval: "interval",
+	scheduleWeekly:   "weekly",
+}
+
+func newExportHabit(h *habit) exportHabit {
+	eh := exportHabit{
+		ID:       h.ID,
+		Content:  h.Content,
+		Position: h.Pos,
+		Created:  h.CreatedAt,
+		Schedule: exportSchedule{
+			Kind:     scheduleKindNames[h.Schedule.Kind],
+			Interval: h.Schedule.Interval,
+			PerWeek:  h.Schedule.PerWeek,
+		},
+		Target: h.Target,
+		Unit:   h.Unit,
+		Value:  h.Value,
+	}
+	if h.isDone() {
+		t := h.CompletedAt
+		eh.Completed = &t
+	}
+	if h.isDeleted() {
+		t := h.DeletedAt
+		eh.Deleted = &t
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if h.Schedule.Weekdays.has(d) {
+			eh.Schedule.Weekdays = append(eh.Schedule.Weekdays, d.String()[:3])
+		}
+	}
+	return eh
+}
+
+func (eh *exportHabit) toHabit() (habit, error) {
+	h := habit{
+		ID:        eh.ID,
+		Content:   eh.Content,
+		Pos:       eh.Position,
+		CreatedAt: eh.Created,
+		Target:    eh.Target,
+		Unit:      eh.Unit,
+		Value:     eh.Value,
+	}
+	if eh.ID <= 0 {
+		return habit{}, fmt.Errorf("habit %q has an invalid ID of %d", eh.Content, eh.ID)
+	}
+	if eh.Completed != nil {
+		h.CompletedAt = *eh.Completed
+	}
+	if eh.Deleted != nil {
+		h.DeletedAt = *eh.Deleted
+	}
+	found := false
+	for k, name := range scheduleKindNames {
+		if name == eh.Schedule.Kind {
+			h.Schedule.Kind = k
+			found = true
+			break
+		}
+	}
+	if !found {
+		return habit{}, fmt.Errorf("habit %d has an unknown schedule kind %q", eh.ID, eh.Schedule.Kind)
+	}
+	h.Schedule.Interval = eh.Schedule.Interval
+	h.Schedule.PerWeek = eh.Schedule.PerWeek
+	for _, name := range eh.Schedule.Weekdays {
+		d, ok := parseWeekday(name)
+		if !ok {
+			return habit{}, fmt.Errorf("habit %d has an unknown weekday %q", eh.ID, name)
+		}
+		h.Schedule.Weekdays = h.Schedule.Weekdays.with(d)
+	}
+	if err := h.Schedule.validate(); err != nil {
+		return habit{}, fmt.Errorf("habit %d: %w", eh.ID, err)
+	}
+	if h.Target < 0 {
+		return habit{}, fmt.Errorf("habit %d: %s is not a valid target", eh.ID, fmtQuantity(h.Target))
+	}
+	return h, nil
+}
+
+// parseWeekday parses a weekday's full or three letter name.
+func parseWeekday(name string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if name == d.String() || name == d.String()[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// exportData gathers the entire database into the export format.
+func (s *store) exportData() (exportFile, error) {
+	ef := exportFile{
+		Format:   exportFormatName,
+		Version:  exportFormatVersion,
+		Exported: time.Now(),
+		Habits:   []exportHabit{},
+		Days:     []exportDay{},
+	}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var templates []habit
+		if err := get(bucketOf(tx, "meta"), []byte("habits"), &templates); err != nil {
+			return fmt.Errorf("getting habit template list from meta: %w", err)
+		}
+		for i := range templates {
+			ef.Habits = append(ef.Habits, newExportHabit(&templates[i]))
+		}
+		sums := bucketOf(tx, "dailySummaries")
+		dailys := bucketOf(tx, "dailyRecords")
+		return dailys.ForEach(func(k, v []byte) error {
+			t, err := parseKey(string(k))
+			if err != nil {
+				return fmt.Errorf("parsing record key %q: %w", string(k), err)
+			}
+			var items []habit
+			if err := decode(dailys, k, v, &items); err != nil {
+				return fmt.Errorf("decoding habits for %q: %w", string(k), err)
+			}
+			var summary dailySummary
+			if err := get(sums, k, &summary); err != nil {
+				return fmt.Errorf("decoding summary for %q: %w", string(k), err)
+			}
+			day := exportDay{
+				Date:    t.Format("2006-01-02"),
+				Habits:  make([]exportHabit, len(items)),
+				Summary: exportSummary{Completed: summary.NumCompl, Percent: summary.PctCompl},
+			}
+			for i := range items {
+				day.Habits[i] = newExportHabit(&items[i])
+			}
+			ef.Days = append(ef.Days, day)
+			return nil
+		})
+	})
+	return ef, err
+}
+
+// importData replaces the entire database with the contents of the given
+// export after validating it. Summaries are recomputed from the imported
+// days rather than trusted.
+func (s *store) importData(ef *exportFile) error {
+	if ef.Format != exportFormatName {
+		return fmt.Errorf("not a Todaily export (format is %q)", ef.Format)
+	}
+	if ef.Version < 1 || ef.Version > exportFormatVersion {
+		return fmt.Errorf("unsupported export version %d (this version of Todaily supports up to %d)", ef.Version, exportFormatVersion)
+	}
+	templates := make([]habit, len(ef.Habits))
+	ids := make(map[int]bool, len(ef.Habits))
+	maxID := 0
+	for i := range ef.Habits {
+		h, err := ef.Habits[i].toHabit()
+		if err != nil {
+			return err
+		}
+		if ids[h.ID] {
+			return fmt.Errorf("more than one habit has the ID %d", h.ID)
+		}
+		ids[h.ID] = true
+		if h.ID > maxID {
+			maxID = h.ID
+		}
+		templates[i] = h
+	}
+	today := todayKey()
+	days := make(map[string][]habit, len(ef.Days))
+	for _, d := range ef.Days {
+		t, err := parseDate(d.Date)
+		if err != nil {
+			return fmt.Errorf("invalid date %q: %w", d.Date, err)
+		}
+		k := t.Format(keyFormat)
+		if k > today {
+			return fmt.Errorf("day %s is in the future", d.Date)
+		}
+		if _, ok := days[k]; ok {
+			return fmt.Errorf("day %s appears more than once", d.Date)
+		}
+		items := make([]habit, len(d.Habits))
+		dayIDs := make(map[int]bool, len(d.Habits))
+		for i := range d.Habits {
+			h, err := d.Habits[i].toHabit()
+			if err != nil {
+				return fmt.Errorf("day %s: %w", d.Date, err)
+			}
+			if !ids[h.ID] {
+				return fmt.Errorf("day %s: habit %d isn't in the habit list", d.Date, h.ID)
+			}
+			if dayIDs[h.ID] {
+				return fmt.Errorf("day %s: habit %d appears more than once", d.Date, h.ID)
+			}
+			dayIDs[h.ID] = true
+			items[i] = h
+		}
+		days[k] = items
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket([]byte("dailyRecords")); err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+			return fmt.Errorf("clearing daily records: %w", err)
+		}
+		if _, err := tx.CreateBucket([]byte("dailyRecords")); err != nil {
+			return fmt.Errorf("creating daily records bucket: %w", err)
+		}
+		dailys := bucketOf(tx, "dailyRecords")
+		meta := bucketOf(tx, "meta")
+		if err := put(meta, []byte("habits"), templates); err != nil {
+			return fmt.Errorf("putting habit template list into meta: %w", err)
+		}
+		if meta.Sequence() < uint64(maxID) {
+			if err := meta.SetSequence(uint64(maxID)); err != nil {
+				return fmt.Errorf("setting habit ID sequence: %w", err)
+			}
+		}
+		for k, items := range days {
+			if err := put(dailys, []byte(k), items); err != nil {
+				return fmt.Errorf("inserting habits for %q: %w", k, err)
+			}
+		}
+		return rebuildSummaries(tx)
+	})
+}
+
+func runExport(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "export")
+	outFile := fs.String("o", "", "The file to write to (defaults to stdout).")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var ef exportFile
+	if err := env.withStore(*dbFile, func(s *store) (err error) {
+		ef, err = s.exportData()
+		return err
+	}); err != nil {
+		return err
+	}
+	w := env.stdout
+	if *outFile != "" {
+		f, err := os.Create(env.path(*outFile))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ef)
+}
+
+func runImport(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "import")
+	replace := fs.Bool("replace", false, "Replace a database that already has habits in it.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs)
+	}
+
+	data, err := os.ReadFile(env.path(fs.Arg(0)))
+	if err != nil {
+		return err
+	}
+	var ef exportFile
+	if err := json.Unmarshal(data, &ef); err != nil {
+		return fmt.Errorf("decoding %s: %w", fs.Arg(0), err)
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		existing, err := s.getHabits()
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 && !*replace {
+			return errors.New("the database already has habits in it (use -replace to overwrite it)")
+		}
+		if err := s.importData(&ef); err != nil {
+			return err
+		}
+		fmt.Fprintf(env.stdout, "Imported %d habits and %d days.\n", len(ef.Habits), len(ef.Days))
+		return nil
+	})
+}
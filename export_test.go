@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestToHabitSchedule(t *testing.T) {
+	tests := []struct {
+		sched exportSchedule
+		ok    bool
+	}{
+		{exportSchedule{Kind: "daily"}, true},
+		{exportSchedule{Kind: "interval", Interval: 3}, true},
+		{exportSchedule{Kind: "weekly", PerWeek: 7}, true},
+		{exportSchedule{Kind: "monthly"}, false},
+		{exportSchedule{Kind: "interval", Interval: -2}, false},
+		{exportSchedule{Kind: "weekly", PerWeek: 8}, false},
+		{exportSchedule{Kind: "weekly", PerWeek: -1}, false},
+		{exportSchedule{Kind: "weekly"}, false},
+		{exportSchedule{Kind: "daily", PerWeek: 9}, false},
+	}
+	for _, tt := range tests {
+		eh := exportHabit{ID: 1, Content: "Read", Schedule: tt.sched}
+		_, err := eh.toHabit()
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%+v: got error %v, want ok = %v", tt.sched, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// fsckProblem is something wrong found in the database by `checkIntegrity`.
+type fsckProblem struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key,omitempty"`
+	Desc   string `json:"problem"`
+	// Fixable problems are ones that rebuilding the summaries fixes.
+	Fixable bool `json:"fixable"`
+}
+
+func (p fsckProblem) String() string {
+	if p.Key == "" {
+		return p.Bucket + ": " + p.Desc
+	}
+	return p.Bucket + " " + p.Key + ": " + p.Desc
+}
+
+// checkIntegrity reads through every bucket and reports anything that can't
+// be decoded, doesn't belong, or doesn't agree with the rest of the database.
+func (s *store) checkIntegrity() (problems []fsckProblem, _ error) {
+	report := func(bucket, key string, fixable bool, format string, a ...any) {
+		problems = append(problems, fsckProblem{
+			Bucket:  bucket,
+			Key:     key,
+			Desc:    fmt.Sprintf(format, a...),
+			Fixable: fixable,
+		})
+	}
+	// checkKey checks that a key is a date that isn't in the future.
+	today := todayKey()
+	checkKey := func(bucket string, k []byte) (time.Time, bool) {
+		t, err := parseKey(string(k))
+		if err != nil {
+			report(bucket, fmt.Sprintf("%q", k), false, "key isn't a YYYYMMDD date")
+			return time.Time{}, false
+		}
+		if string(k) > today {
+			report(bucket, string(k), false, "date is in the future")
+		}
+		return t, true
+	}
+
+	return problems, s.db.View(func(tx *bbolt.Tx) error {
+		for _, name := range []string{"meta", "dailyRecords", "dailySummaries", "weeklySummaries"} {
+			if tx.Bucket([]byte(name)) == nil {
+				report(name, "", false, "bucket is missing")
+				return nil
+			}
+		}
+
+		// The habit template list.
+		meta := bucketOf(tx, "meta")
+		var templates []habit
+		templateIDs := make(map[int]bool)
+		if err := get(meta, []byte("habits"), &templates); err != nil {
+			report("meta", "habits", false, "can't be decoded: %v", err)
+		}
+		for _, h := range templates {
+			if templateIDs[h.ID] {
+				report("meta", "habits", false, "habit ID %d is used more than once", h.ID)
+			}
+			templateIDs[h.ID] = true
+			if uint64(h.ID) > meta.Sequence() {
+				report("meta", "habits", false, "habit ID %d is past the last ID handed out (%d)", h.ID, meta.Sequence())
+			}
+		}
+
+		// Daily records, which everything else is computed from.
+		records := make(map[string][]habit)
+		weeks := make(map[string]time.Time)
+		dailys := bucketOf(tx, "dailyRecords")
+		if err := dailys.ForEach(func(k, v []byte) error {
+			t, ok := checkKey("dailyRecords", k)
+			var items []habit
+			if err := decode(dailys, k, v, &items); err != nil {
+				report("dailyRecords", string(k), false, "can't be decoded: %v", err)
+				return nil
+			}
+			if !ok {
+				return nil
+			}
+			seen := make(map[int]bool, len(items))
+			for _, h := range items {
+				if seen[h.ID] {
+					report("dailyRecords", string(k), false, "habit ID %d is listed more than once", h.ID)
+				}
+				seen[h.ID] = true
+				if len(templates) > 0 && !templateIDs[h.ID] {
+					report("dailyRecords", string(k), false, "habit ID %d (%q) isn't in the habit list", h.ID, h.Content)
+				}
+			}
+			records[string(k)] = items
+			weeks[weekStart(t).Format(keyFormat)] = weekStart(t)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		// Daily summaries should match their records.
+		sums := bucketOf(tx, "dailySummaries")
+		if err := sums.ForEach(func(k, v []byte) error {
+			checkKey("dailySummaries", k)
+			var summary dailySummary
+			if err := decode(sums, k, v, &summary); err != nil {
+				report("dailySummaries", string(k), true, "can't be decoded: %v", err)
+				return nil
+			}
+			items, ok := records[string(k)]
+			if !ok {
+				if dailys.Get(k) == nil {
+					report("dailySummaries", string(k), true, "there's no record for this day")
+				}
+				return nil
+			}
+			want := newSummaryOfList(items)
+			if summary.NumCompl != want.NumCompl || !closeEnough(summary.PctCompl, want.PctCompl) {
+				report("dailySummaries", string(k), true, "says %d done (%.0f%%) but the record has %d done (%.0f%%)",
+					summary.NumCompl, summary.PctCompl*100, want.NumCompl, want.PctCompl*100)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k := range records {
+			if sums.Get([]byte(k)) == nil {
+				report("dailySummaries", k, true, "the day has a record but no summary")
+			}
+		}
+
+		// Weekly summaries should match the records of their weeks.
+		weeklys := bucketOf(tx, "weeklySummaries")
+		if err := weeklys.ForEach(func(k, v []byte) error {
+			t, ok := checkKey("weeklySummaries", k)
+			var summary weeklySummary
+			if err := decode(weeklys, k, v, &summary); err != nil {
+				report("weeklySummaries", string(k), true, "can't be decoded: %v", err)
+				return nil
+			}
+			if !ok {
+				return nil
+			}
+			if t.Weekday() != time.Sunday {
+				report("weeklySummaries", string(k), true, "isn't keyed by the Sunday that begins its week")
+				return nil
+			}
+			if _, ok := weeks[string(k)]; !ok {
+				report("weeklySummaries", string(k), true, "there are no records for this week")
+				return nil
+			}
+			if want := recordsWeeklySummary(records, t); summary != want {
+				report("weeklySummaries", string(k), true, "says %d of %d goals met but the records have %d of %d",
+					summary.NumMet, summary.NumGoals, want.NumMet, want.NumGoals)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k := range weeks {
+			if weeklys.Get([]byte(k)) == nil {
+				report("weeklySummaries", k, true, "the week has records but no summary")
+			}
+		}
+
+		sort.SliceStable(problems, func(i, j int) bool {
+			if problems[i].Bucket != problems[j].Bucket {
+				return problems[i].Bucket < problems[j].Bucket
+			}
+			return problems[i].Key < problems[j].Key
+		})
+		return nil
+	})
+}
+
+// recordsWeeklySummary computes the summary for the week starting at `start`
+// from already decoded daily records, like `updateWeeklySummary` does.
+func recordsWeeklySummary(records map[string][]habit, start time.Time) weeklySummary {
+	counts := make(map[int]int)
+	goals := make(map[int]int)
+	for i := 0; i < 7; i++ {
+		addWeeklyGoals(records[start.AddDate(0, 0, i).Format(keyFormat)], counts, goals)
+	}
+	return newWeeklySummary(counts, goals)
+}
+
+func closeEnough(a, b float32) bool {
+	d := a - b
+	return d > -0.0001 && d < 0.0001
+}
+
+// rebuildAllSummaries recomputes every daily and weekly summary from the
+// daily records, leaving out any records that can't be read. It returns the
+// keys of those records.
+func (s *store) rebuildAllSummaries() (skipped []string, _ error) {
+	return skipped, s.db.Update(func(tx *bbolt.Tx) error {
+		skipped = nil
+		return rebuildSummariesWith(tx, func(k []byte, _ error) error {
+			skipped = append(skipped, fmt.Sprintf("%q", k))
+			return nil
+		})
+	})
+}
+
+func runFsck(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "fsck")
+	rebuild := fs.Bool("rebuild", false, "Recompute the daily and weekly summaries from the daily records.")
+	asJSON := fs.Bool("json", false, "Write the problems found as JSON.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		problems, err := s.checkIntegrity()
+		if err != nil {
+			return err
+		}
+		text := "No problems found.\n"
+		if len(problems) > 0 {
+			text = ""
+			numFixable := 0
+			for _, p := range problems {
+				text += p.String() + "\n"
+				if p.Fixable {
+					numFixable++
+				}
+			}
+			if numFixable > 0 && !*rebuild {
+				text += fmt.Sprintf("\n%d of these can be fixed by running with -rebuild.\n", numFixable)
+			}
+		}
+		numLeft := len(problems)
+		if *rebuild {
+			skipped, err := s.rebuildAllSummaries()
+			if err != nil {
+				return fmt.Errorf("rebuilding summaries: %w", err)
+			}
+			text += "\nRebuilt the summaries"
+			if len(skipped) > 0 {
+				text += fmt.Sprintf(", leaving out %d daily records that can't be read: %s", len(skipped), strings.Join(skipped, ", "))
+			}
+			text += ".\n"
+			// Whatever the rebuild didn't fix is still a problem.
+			left, err := s.checkIntegrity()
+			if err != nil {
+				return err
+			}
+			numLeft = len(left)
+		}
+		if problems == nil {
+			problems = []fsckProblem{}
+		}
+		if err := (output{env.stdout, *asJSON}).write(problems, text); err != nil {
+			return err
+		}
+		if numLeft > 0 {
+			return fmt.Errorf("found %d problems", numLeft)
+		}
+		return nil
+	})
+}
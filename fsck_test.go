@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckIntegrityOpenedDay(t *testing.T) {
+	s, err := openStore(filepath.Join(t.TempDir(), "db.todaily"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	created := time.Now().AddDate(0, 0, -10)
+	templates := []habit{
+		{CreatedAt: created, Content: "Read"},
+		{CreatedAt: created, Content: "Walk", Schedule: schedule{Kind: scheduleWeekly, PerWeek: 3}, Pos: 1},
+	}
+	for i := range templates {
+		if templates[i].ID, err = s.newHabitID(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.putHabits(templates); err != nil {
+		t.Fatal(err)
+	}
+	// Opening a day writes its record without anything being changed.
+	for _, k := range []string{todayKey(), dayOf(time.Now()).AddDate(0, 0, -8).Format(keyFormat)} {
+		if _, err := s.getHabitsForDay(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := s.checkIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("unexpected problem: %s", p)
+	}
+}
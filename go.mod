@@ -6,7 +6,9 @@ require (
 	gioui.org v0.0.0-20221116222243-5c84cf7e9021
 	github.com/steverusso/gio-fonts v0.0.0-20221118164031-86919e20ff66
 	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.1.0
 	golang.org/x/exp/shiny v0.0.0-20220827204233-334a2380cb91
+	golang.org/x/term v0.1.0
 )
 
 require (
@@ -16,6 +18,6 @@ require (
 	github.com/gioui/uax v0.2.1-0.20220819135011-cda973fac06d // indirect
 	github.com/go-text/typesetting v0.0.0-20220411150340-35994bc27a7b // indirect
 	golang.org/x/image v0.0.0-20220722155232-062f8c9fd539 // indirect
-	golang.org/x/sys v0.0.0-20220825204002-c680a09ffe64 // indirect
-	golang.org/x/text v0.3.7 // indirect
+	golang.org/x/sys v0.1.0 // indirect
+	golang.org/x/text v0.4.0 // indirect
 )
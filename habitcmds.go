@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// output is where a subcommand writes its results, either as plain text or
+// as JSON for scripts.
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// write writes out `v` as indented JSON if JSON output was asked for, or the
+// given text otherwise.
+func (o output) write(v any, text string) error {
+	if o.json {
+		enc := json.NewEncoder(o.w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	_, err := io.WriteString(o.w, text)
+	return err
+}
+
+// parseInterspersed parses the given arguments like `parseFlags` while
+// allowing flags to come after positional arguments (e.g. `todaily check Read
+// -date 2026-10-15`), and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := parseFlags(fs, args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// parseDateFlag returns the database key for a YYYY-MM-DD date, or for today
+// if the date is empty.
+func parseDateFlag(date string) (string, error) {
+	if date == "" {
+		return todayKey(), nil
+	}
+	t, err := parseDate(date)
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q: %w", date, err)
+	}
+	return t.Format(keyFormat), nil
+}
+
+// findHabit returns the index of the habit in `items` referred to by `ref`,
+// which is either a habit's ID, its full name or the unique start of its name.
+// Names are matched regardless of case.
+func findHabit(items []habit, ref string) (int, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		for i := range items {
+			if items[i].ID == id {
+				return i, nil
+			}
+		}
+	}
+	for i := range items {
+		if strings.EqualFold(items[i].Content, ref) {
+			return i, nil
+		}
+	}
+	found := -1
+	lower := strings.ToLower(ref)
+	for i := range items {
+		if !strings.HasPrefix(strings.ToLower(items[i].Content), lower) {
+			continue
+		}
+		if found != -1 {
+			return -1, fmt.Errorf("%q could be %q or %q", ref, items[found].Content, items[i].Content)
+		}
+		found = i
+	}
+	if found == -1 {
+		return -1, fmt.Errorf("no habit matching %q", ref)
+	}
+	return found, nil
+}
+
+// dayItem is how a single habit in a day's record is shown by `list`.
+type dayItem struct {
+	ID        int        `json:"id"`
+	Content   string     `json:"content"`
+	Done      bool       `json:"done"`
+	Completed *time.Time `json:"completed,omitempty"`
+	Schedule  string     `json:"schedule"`
+	Target    float64    `json:"target,omitempty"`
+	Unit      string     `json:"unit,omitempty"`
+	Value     float64    `json:"value,omitempty"`
+	// WeekDone and WeekGoal are the progress towards a weekly goal habit,
+	// counting the day itself.
+	WeekDone int `json:"weekDone,omitempty"`
+	WeekGoal int `json:"weekGoal,omitempty"`
+}
+
+func newDayItem(h *habit, weekCompl map[int]int) dayItem {
+	di := dayItem{
+		ID:       h.ID,
+		Content:  h.Content,
+		Done:     h.isDone(),
+		Schedule: h.Schedule.String(),
+		Target:   h.Target,
+		Unit:     h.Unit,
+		Value:    h.Value,
+	}
+	if h.isDone() {
+		t := h.CompletedAt
+		di.Completed = &t
+	}
+	if h.Schedule.isWeeklyGoal() {
+		di.WeekDone = weekCompl[h.ID]
+		if h.isDone() {
+			di.WeekDone++
+		}
+		di.WeekGoal = h.Schedule.PerWeek
+	}
+	return di
+}
+
+func (di *dayItem) String() string {
+	mark := "[ ]"
+	if di.Done {
+		mark = "[x]"
+	}
+	s := fmt.Sprintf("%s %3d  %s", mark, di.ID, di.Content)
+	var details []string
+	if di.Target > 0 {
+		progress := fmtQuantity(di.Value) + "/" + fmtQuantity(di.Target)
+		if di.Unit != "" {
+			progress += " " + di.Unit
+		}
+		details = append(details, progress)
+	}
+	if di.WeekGoal > 0 {
+		details = append(details, fmt.Sprintf("%d/%d this week", di.WeekDone, di.WeekGoal))
+	} else if di.Schedule != "Every day" {
+		details = append(details, di.Schedule)
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+// dayStatusReport is the overall progress for a day shown by `status`.
+type dayStatusReport struct {
+	Date      string  `json:"date"`
+	Due       int     `json:"due"`
+	Completed int     `json:"completed"`
+	Percent   float32 `json:"percent"`
+	WeekGoals int     `json:"weekGoals"`
+	WeekMet   int     `json:"weekMet"`
+}
+
+func runList(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "list")
+	date := fs.String("date", "", "The day to list, as YYYY-MM-DD (defaults to today).")
+	asJSON := fs.Bool("json", false, "Write the output as JSON.")
+	if rest, err := parseInterspersed(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usageError(fs)
+	}
+	fmtDate, err := parseDateFlag(*date)
+	if err != nil {
+		return err
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		items, err := s.viewHabitsForDay(fmtDate)
+		if err != nil {
+			return err
+		}
+		weekCompl, err := s.getWeekProgress(fmtDate)
+		if err != nil {
+			return err
+		}
+		list := make([]dayItem, len(items))
+		var b strings.Builder
+		for i := range items {
+			list[i] = newDayItem(&items[i], weekCompl)
+			b.WriteString(list[i].String() + "\n")
+		}
+		if len(items) == 0 {
+			b.WriteString("Nothing is due.\n")
+		}
+		return output{env.stdout, *asJSON}.write(list, b.String())
+	})
+}
+
+func runCheck(env *cmdEnv, args []string) error {
+	return runSetDone(env, "check", args, true)
+}
+
+func runUncheck(env *cmdEnv, args []string) error {
+	return runSetDone(env, "uncheck", args, false)
+}
+
+// runSetDone marks a habit in a day's record as done or not done. Marking a
+// quantitative habit done logs its full target unless a value is given.
+func runSetDone(env *cmdEnv, name string, args []string, done bool) error {
+	fs, dbFile := newCommandFlags(env, name)
+	date := fs.String("date", "", "The day of the habit, as YYYY-MM-DD (defaults to today).")
+	asJSON := fs.Bool("json", false, "Write the output as JSON.")
+	var value *float64
+	if done {
+		value = fs.Float64("value", -1, "The amount to log for a habit with a daily target (defaults to the target).")
+	}
+	refs, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(refs) != 1 {
+		return usageError(fs)
+	}
+	fmtDate, err := parseDateFlag(*date)
+	if err != nil {
+		return err
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		items, err := s.getHabitsForDay(fmtDate)
+		if err != nil {
+			return err
+		}
+		i, err := findHabit(items, refs[0])
+		if err != nil {
+			return err
+		}
+		item := &items[i]
+		now := time.Now()
+		switch {
+		case item.isQuantity() && done:
+			v := item.Target
+			if *value >= 0 {
+				v = *value
+			}
+			item.setValue(v, now)
+		case item.isQuantity():
+			item.setValue(0, now)
+		case done && !item.isDone():
+			item.CompletedAt = now
+		case !done:
+			item.CompletedAt = time.Time{}
+		}
+		if err := s.putHabitsForDay(fmtDate, items); err != nil {
+			return fmt.Errorf("saving habits for %q: %w", fmtDate, err)
+		}
+		weekCompl, err := s.getWeekProgress(fmtDate)
+		if err != nil {
+			return err
+		}
+		di := newDayItem(item, weekCompl)
+		return output{env.stdout, *asJSON}.write(di, di.String()+"\n")
+	})
+}
+
+func runAdd(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "add")
+	days := fs.String("days", "", "Only due on these weekdays, e.g. Mon,Wed,Fri.")
+	weekdays := fs.Bool("weekdays", false, "Only due Monday through Friday.")
+	interval := fs.Int("every", 0, "Only due every N days.")
+	perWeek := fs.Int("per-week", 0, "A weekly goal of N (1 to 7) times a week.")
+	target := fs.Float64("target", 0, "An amount to reach each day, making this a quantitative habit.")
+	unit := fs.String("unit", "", "The unit of the daily target (e.g. glasses).")
+	asJSON := fs.Bool("json", false, "Write the output as JSON.")
+	words, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	content := strings.Join(words, " ")
+	if content == "" || *interval < 0 || *perWeek < 0 || *perWeek > 7 {
+		return usageError(fs)
+	}
+
+	var sched schedule
+	numKinds := 0
+	if *days != "" {
+		numKinds++
+		sched = schedule{Kind: scheduleWeekdays}
+		for _, name := range strings.Split(*days, ",") {
+			d, ok := parseWeekday(strings.TrimSpace(name))
+			if !ok {
+				return fmt.Errorf("unknown weekday %q", name)
+			}
+			sched.Weekdays = sched.Weekdays.with(d)
+		}
+	}
+	if *weekdays {
+		numKinds++
+		sched = schedule{Kind: scheduleWeekdays, Weekdays: workWeek}
+	}
+	if *interval > 0 {
+		numKinds++
+		sched = schedule{Kind: scheduleInterval, Interval: *interval}
+	}
+	if *perWeek > 0 {
+		numKinds++
+		sched = schedule{Kind: scheduleWeekly, PerWeek: *perWeek}
+	}
+	if numKinds > 1 {
+		return errors.New("only one of -days, -weekdays, -every and -per-week can be given")
+	}
+	if *target < 0 {
+		return fmt.Errorf("%s is not a valid target", fmtQuantity(*target))
+	}
+
+	return env.withStore(*dbFile, func(s *store) error {
+		templates, err := s.getHabits()
+		if err != nil {
+			return err
+		}
+		id, err := s.newHabitID()
+		if err != nil {
+			return err
+		}
+		h := habit{
+			ID:        id,
+			CreatedAt: time.Now(),
+			Content:   content,
+			Schedule:  sched,
+			Target:    *target,
+			Unit:      *unit,
+			Pos:       len(templates),
+		}
+		templates = append(templates, h)
+		if err := s.putHabits(templates); err != nil {
+			return fmt.Errorf("saving habits: %w", err)
+		}
+		if _, err := s.applyTemplateToDay(todayKey(), templates); err != nil {
+			return fmt.Errorf("applying habits to today: %w", err)
+		}
+		text := fmt.Sprintf("Added %q with ID %d.\n", h.Content, h.ID)
+		return output{env.stdout, *asJSON}.write(newExportHabit(&h), text)
+	})
+}
+
+func runStatus(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "status")
+	date := fs.String("date", "", "The day to report on, as YYYY-MM-DD (defaults to today).")
+	asJSON := fs.Bool("json", false, "Write the output as JSON.")
+	if rest, err := parseInterspersed(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usageError(fs)
+	}
+	fmtDate, err := parseDateFlag(*date)
+	if err != nil {
+		return err
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		items, err := s.viewHabitsForDay(fmtDate)
+		if err != nil {
+			return err
+		}
+		week, err := s.viewWeeklySummary(fmtDate, items)
+		if err != nil {
+			return err
+		}
+		summary := newSummaryOfList(items)
+		t, _ := parseKey(fmtDate)
+		report := dayStatusReport{
+			Date:      t.Format("2006-01-02"),
+			Completed: summary.NumCompl,
+			Percent:   summary.PctCompl,
+			WeekGoals: week.NumGoals,
+			WeekMet:   week.NumMet,
+		}
+		for i := range items {
+			if !items[i].Schedule.isWeeklyGoal() {
+				report.Due++
+			}
+		}
+		text := fmt.Sprintf("%s: %d of %d done (%.0f%%)\n", t.Format("Mon, Jan 2 2006"), report.Completed, report.Due, report.Percent*100)
+		if report.WeekGoals > 0 {
+			text += fmt.Sprintf("Weekly goals met: %d of %d\n", report.WeekMet, report.WeekGoals)
+		}
+		return output{env.stdout, *asJSON}.write(report, text)
+	})
+}
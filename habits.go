@@ -1,10 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"gioui.org/gesture"
+	"gioui.org/io/pointer"
 	"gioui.org/layout"
 	"gioui.org/op"
+	"gioui.org/op/clip"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 )
@@ -13,24 +21,42 @@ type habitScreen struct {
 	store      *store
 	updates    chan<- any
 	habits     []habit
+	streaks    map[int]streak
 	list       widget.List
 	applyToday widget.Clickable
 	done       widget.Clickable
 	newItem    widget.Editor
-	errors     errorList
-	invalidate func()
+	schedKind  widget.Enum
+	schedDays  [7]widget.Bool
+	schedEvery widget.Editor
+	schedTimes widget.Editor
+	target     widget.Editor
+	unit       widget.Editor
+	rows       map[int]*habitRow
+	// selected is the ID of the habit that keyboard reordering applies to.
+	selected int
+	// lastDeleted is the ID of the most recently deleted habit, which can
+	// still be undone.
+	lastDeleted int
+	undoDelete  widget.Clickable
+	errors      errorList
+	invalidate  func()
+	// saves runs the screen's changes against the store.
+	saves saveQueue
 }
 
 func (hs *habitScreen) layout(gtx C, th *material.Theme) D {
+	// These wait for any changes still being saved.
 	if hs.applyToday.Clicked() {
-		go func() {
-			hs.updates <- applyHabitsToToday{hs.habits}
-		}()
+		habits := append([]habit(nil), hs.habits...)
+		hs.saves.do(func() {
+			hs.updates <- applyHabitsToToday{habits}
+		})
 	}
 	if hs.done.Clicked() {
-		go func() {
+		hs.saves.do(func() {
 			hs.updates <- closeHabitScreen{}
-		}()
+		})
 	}
 	layInfoPoint := func(gtx C, txt string) D {
 		lbl := material.Body1(th, txt)
@@ -78,20 +104,15 @@ func (hs *habitScreen) layout(gtx C, th *material.Theme) D {
 		},
 		// Items.
 		func(gtx C) D {
-			rows := make([]layout.FlexChild, len(hs.habits))
+			var rows []layout.FlexChild
 			for i := range hs.habits {
 				item := &hs.habits[i]
-				rows[i] = layout.Rigid(func(gtx C) D {
-					return layout.Inset{Bottom: 5}.Layout(gtx, func(gtx C) D {
-						return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
-							layout.Rigid(func(gtx C) D {
-								return iconUnchecked.Layout(gtx, th.Fg)
-							}),
-							layout.Rigid(layout.Spacer{Width: 5}.Layout),
-							layout.Rigid(material.Body1(th, item.Content).Layout),
-						)
-					})
-				})
+				if item.isDeleted() {
+					continue
+				}
+				rows = append(rows, layout.Rigid(func(gtx C) D {
+					return hs.layHabitRow(gtx, th, item)
+				}))
 			}
 			return layout.Inset{Top: 24, Right: 20, Bottom: 12, Left: 55}.Layout(gtx, func(gtx C) D {
 				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, rows...)
@@ -101,18 +122,26 @@ func (hs *habitScreen) layout(gtx C, th *material.Theme) D {
 		func(gtx C) D {
 			for _, e := range hs.newItem.Events() {
 				if e, ok := e.(widget.SubmitEvent); ok {
-					hs.habits = append(hs.habits, habit{
-						ID:        len(hs.habits) + 1,
+					sched, err := hs.inputSchedule()
+					if err != nil {
+						hs.errors.add("adding habit", err)
+						continue
+					}
+					target, err := hs.inputTarget()
+					if err != nil {
+						hs.errors.add("adding habit", err)
+						continue
+					}
+					hs.addHabit(habit{
 						CreatedAt: time.Now(),
 						Content:   e.Text,
+						Schedule:  sched,
+						Target:    target,
+						Unit:      hs.unit.Text(),
 					})
-					go func() {
-						if err := hs.store.putHabits(hs.habits); err != nil {
-							hs.errors.add("saving habits", err)
-							hs.invalidate()
-						}
-					}()
 					hs.newItem.SetText("")
+					hs.target.SetText("")
+					hs.unit.SetText("")
 					op.InvalidateOp{}.Add(gtx.Ops)
 				}
 			}
@@ -120,6 +149,40 @@ func (hs *habitScreen) layout(gtx C, th *material.Theme) D {
 				return editor{th, &hs.newItem, "Add new habit..."}.layout(gtx)
 			})
 		},
+		// Schedule for the new item.
+		func(gtx C) D {
+			return layout.Inset{Right: 20, Bottom: 20, Left: 60}.Layout(gtx, func(gtx C) D {
+				return hs.layScheduleInput(gtx, th)
+			})
+		},
+		// Optional daily target for the new item.
+		func(gtx C) D {
+			return layout.Inset{Right: 20, Bottom: 20, Left: 60}.Layout(gtx, func(gtx C) D {
+				return hs.layTargetInput(gtx, th)
+			})
+		},
+		// Archived (deleted) items.
+		func(gtx C) D {
+			var rows []layout.FlexChild
+			for i := range hs.habits {
+				item := &hs.habits[i]
+				if !item.isDeleted() {
+					continue
+				}
+				rows = append(rows, layout.Rigid(func(gtx C) D {
+					return hs.layArchivedRow(gtx, th, item)
+				}))
+			}
+			if len(rows) == 0 {
+				return D{}
+			}
+			heading := layout.Rigid(func(gtx C) D {
+				return layout.Inset{Bottom: 12}.Layout(gtx, material.H6(th, "Archived Habits").Layout)
+			})
+			return layout.Inset{Top: 12, Right: 20, Bottom: 20, Left: 20}.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx, append([]layout.FlexChild{heading}, rows...)...)
+			})
+		},
 	}
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Flexed(1, func(gtx C) D {
@@ -127,14 +190,461 @@ func (hs *habitScreen) layout(gtx C, th *material.Theme) D {
 				return widgets[i](gtx)
 			})
 		}),
+		layout.Rigid(func(gtx C) D {
+			return hs.layUndoBar(gtx, th)
+		}),
 		layout.Rigid(func(gtx C) D {
 			return hs.errors.layout(gtx, th)
 		}),
 	)
 }
 
+// habitRow holds the widget state for one habit on the Manage Habits screen.
+type habitRow struct {
+	edit    widget.Clickable
+	remove  widget.Clickable
+	restore widget.Clickable
+	editing bool
+	content widget.Editor
+	drag    gesture.Drag
+	// dragStart is where the handle was pressed, and height is the row's
+	// height as of the last frame, for knowing when a drag passes a neighbor.
+	dragStart float32
+	height    int
+	moved     bool
+}
+
+func (hs *habitScreen) row(id int) *habitRow {
+	if hs.rows == nil {
+		hs.rows = make(map[int]*habitRow)
+	}
+	r, ok := hs.rows[id]
+	if !ok {
+		r = &habitRow{content: widget.Editor{SingleLine: true, Submit: true}}
+		hs.rows[id] = r
+	}
+	return r
+}
+
+func (hs *habitScreen) layHabitRow(gtx C, th *material.Theme, item *habit) D {
+	r := hs.row(item.ID)
+	if r.edit.Clicked() {
+		hs.selected = item.ID
+		r.editing = !r.editing
+		if r.editing {
+			r.content.SetText(item.Content)
+			r.content.Focus()
+		}
+	}
+	for _, e := range r.content.Events() {
+		if e, ok := e.(widget.SubmitEvent); ok {
+			if e.Text == "" {
+				hs.errors.add("renaming habit", errors.New("a habit can't be blank"))
+				continue
+			}
+			item.Content = e.Text
+			r.editing = false
+			hs.saveHabits()
+		}
+	}
+	if r.remove.Clicked() {
+		item.DeletedAt = time.Now()
+		r.editing = false
+		hs.lastDeleted = item.ID
+		hs.saveHabits()
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	layContent := func(gtx C) D {
+		if r.editing {
+			gtx.Constraints.Min.X = 200
+			return editor{th, &r.content, "Habit name"}.layout(gtx)
+		}
+		return material.Body1(th, item.Content).Layout(gtx)
+	}
+	for _, e := range r.drag.Events(gtx.Metric, gtx, gesture.Vertical) {
+		switch e.Type {
+		case pointer.Press:
+			hs.selected = item.ID
+			r.dragStart = e.Position.Y
+		case pointer.Drag:
+			// The handle moves along with its row, so the offset from where it
+			// was pressed shrinks back down after each move.
+			dy := e.Position.Y - r.dragStart
+			if r.height > 0 && dy > float32(r.height) {
+				r.moved = hs.moveHabit(item.ID, 1) || r.moved
+			} else if r.height > 0 && dy < -float32(r.height) {
+				r.moved = hs.moveHabit(item.ID, -1) || r.moved
+			}
+		case pointer.Release, pointer.Cancel:
+			if r.moved {
+				r.moved = false
+				hs.saveHabits()
+			}
+		}
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	layHandle := func(gtx C) D {
+		clr := mutedColor(th.Fg)
+		if hs.selected == item.ID {
+			clr = th.ContrastBg
+		}
+		dims := iconDragHandle.Layout(gtx, clr)
+		defer clip.Rect{Max: dims.Size}.Push(gtx.Ops).Pop()
+		pointer.CursorGrab.Add(gtx.Ops)
+		r.drag.Add(gtx.Ops)
+		return dims
+	}
+	layDetail := func(gtx C) D {
+		detail := habitDetail(item)
+		if st := hs.streaks[item.ID].String(); st != "" {
+			if detail != "" {
+				detail += ", "
+			}
+			detail += st
+		}
+		if detail == "" {
+			return D{}
+		}
+		lbl := material.Caption(th, detail)
+		lbl.Color = mutedColor(th.Fg)
+		return layout.Inset{Left: 10}.Layout(gtx, lbl.Layout)
+	}
+	dims := layout.Inset{Bottom: 5}.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(layHandle),
+			layout.Rigid(layout.Spacer{Width: 5}.Layout),
+			layout.Rigid(func(gtx C) D {
+				return iconUnchecked.Layout(gtx, th.Fg)
+			}),
+			layout.Rigid(layout.Spacer{Width: 5}.Layout),
+			layout.Rigid(layContent),
+			layout.Flexed(1, layDetail),
+			layout.Rigid(rowButton(th, &r.edit, iconEdit, "Edit")),
+			layout.Rigid(rowButton(th, &r.remove, iconDelete, "Delete")),
+		)
+	})
+	r.height = dims.Size.Y
+	return dims
+}
+
+// moveHabit moves the habit with the given ID up (-1) or down (1) past its
+// neighboring habit that hasn't been deleted, and reports whether it moved.
+func (hs *habitScreen) moveHabit(id, dir int) bool {
+	i := -1
+	for n := range hs.habits {
+		if hs.habits[n].ID == id {
+			i = n
+			break
+		}
+	}
+	if i == -1 {
+		return false
+	}
+	for j := i + dir; j >= 0 && j < len(hs.habits); j += dir {
+		if !hs.habits[j].isDeleted() {
+			hs.habits[i], hs.habits[j] = hs.habits[j], hs.habits[i]
+			return true
+		}
+	}
+	return false
+}
+
+// moveSelected moves the selected habit up (-1) or down (1) and saves the new order.
+func (hs *habitScreen) moveSelected(dir int) {
+	if hs.selected != 0 && hs.moveHabit(hs.selected, dir) {
+		hs.saveHabits()
+	}
+}
+
+func (hs *habitScreen) layArchivedRow(gtx C, th *material.Theme, item *habit) D {
+	r := hs.row(item.ID)
+	if r.restore.Clicked() {
+		hs.restore(item)
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	lbl := material.Body1(th, item.Content)
+	lbl.Color = mutedColor(th.Fg)
+	deleted := material.Caption(th, "Deleted "+item.DeletedAt.Format("Jan 2, 2006"))
+	deleted.Color = mutedColor(th.Fg)
+	return layout.Inset{Bottom: 5, Left: 35}.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(lbl.Layout),
+			layout.Flexed(1, func(gtx C) D {
+				return layout.Inset{Left: 10}.Layout(gtx, deleted.Layout)
+			}),
+			layout.Rigid(rowButton(th, &r.restore, iconRestore, "Restore")),
+		)
+	})
+}
+
+// layUndoBar offers to undo the most recent deletion.
+func (hs *habitScreen) layUndoBar(gtx C, th *material.Theme) D {
+	var item *habit
+	for i := range hs.habits {
+		if hs.habits[i].ID == hs.lastDeleted && hs.habits[i].isDeleted() {
+			item = &hs.habits[i]
+			break
+		}
+	}
+	if item == nil {
+		return D{}
+	}
+	if hs.undoDelete.Clicked() {
+		hs.restore(item)
+		op.InvalidateOp{}.Add(gtx.Ops)
+	}
+	undoBtn := material.Button(th, &hs.undoDelete, "Undo")
+	undoBtn.Inset = layout.Inset{Top: 5, Right: 10, Bottom: 5, Left: 10}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(rule{color: th.Fg}.layout),
+		layout.Rigid(func(gtx C) D {
+			return layout.UniformInset(10).Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Flexed(1, material.Body1(th, fmt.Sprintf("Deleted %q.", item.Content)).Layout),
+					layout.Rigid(undoBtn.Layout),
+				)
+			})
+		}),
+	)
+}
+
+// restore brings back a deleted habit.
+func (hs *habitScreen) restore(item *habit) {
+	item.DeletedAt = time.Time{}
+	if hs.lastDeleted == item.ID {
+		hs.lastDeleted = 0
+	}
+	hs.saveHabits()
+}
+
+// addHabit gives the new habit an ID in the background and then has it added
+// to the list (see `habitAdded`).
+func (hs *habitScreen) addHabit(h habit) {
+	hs.saves.do(func() {
+		id, err := hs.store.newHabitID()
+		if err != nil {
+			hs.errors.add("adding habit", err)
+			hs.invalidate()
+			return
+		}
+		h.ID = id
+		hs.updates <- habitAdded{h}
+	})
+}
+
+// saveHabits persists the habit template list, in its current order, in the
+// background.
+func (hs *habitScreen) saveHabits() {
+	for i := range hs.habits {
+		hs.habits[i].Pos = i
+	}
+	habits := append([]habit(nil), hs.habits...)
+	hs.saves.do(func() {
+		if err := hs.store.putHabits(habits); err != nil {
+			hs.errors.add("saving habits", err)
+			hs.invalidate()
+		}
+	})
+}
+
+// saveQueue runs functions in the background one at a time and in the order
+// they were given, so that an older save can never be committed after a newer
+// one.
+type saveQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (q *saveQueue) do(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, fn)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *saveQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		fn()
+	}
+}
+
+func rowButton(th *material.Theme, click *widget.Clickable, ic *widget.Icon, desc string) layout.Widget {
+	btn := material.IconButton(th, click, ic, desc)
+	btn.Background = th.Bg
+	btn.Color = mutedColor(th.Fg)
+	btn.Size = 18
+	btn.Inset = layout.UniformInset(6)
+	return btn.Layout
+}
+
+func (hs *habitScreen) layScheduleInput(gtx C, th *material.Theme) D {
+	kinds := func(gtx C) D {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(material.RadioButton(th, &hs.schedKind, "daily", "Every day").Layout),
+			layout.Rigid(material.RadioButton(th, &hs.schedKind, "weekdays", "Weekdays").Layout),
+			layout.Rigid(material.RadioButton(th, &hs.schedKind, "days", "Certain days").Layout),
+			layout.Rigid(material.RadioButton(th, &hs.schedKind, "interval", "Every few days").Layout),
+			layout.Rigid(material.RadioButton(th, &hs.schedKind, "weekly", "Times per week").Layout),
+		)
+	}
+	var details layout.Widget
+	switch hs.schedKind.Value {
+	case "days":
+		details = func(gtx C) D {
+			var days [7]layout.FlexChild
+			for d := range hs.schedDays {
+				box := material.CheckBox(th, &hs.schedDays[d], time.Weekday(d).String()[:3])
+				days[d] = layout.Rigid(box.Layout)
+			}
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx, days[:]...)
+		}
+	case "interval":
+		details = func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(material.Body2(th, "Every").Layout),
+				layout.Rigid(layout.Spacer{Width: 8}.Layout),
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Max.X = 40
+					gtx.Constraints.Min.X = 40
+					return editor{th, &hs.schedEvery, "N"}.layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: 8}.Layout),
+				layout.Rigid(material.Body2(th, "days").Layout),
+			)
+		}
+	case "weekly":
+		details = func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Max.X = 40
+					gtx.Constraints.Min.X = 40
+					return editor{th, &hs.schedTimes, "N"}.layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: 8}.Layout),
+				layout.Rigid(material.Body2(th, "times a week").Layout),
+			)
+		}
+	default:
+		return kinds(gtx)
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(kinds),
+		layout.Rigid(layout.Spacer{Height: 8}.Layout),
+		layout.Rigid(details),
+	)
+}
+
+func (hs *habitScreen) layTargetInput(gtx C, th *material.Theme) D {
+	hint := material.Caption(th, "Leave the target empty for a habit that's simply checked off.")
+	hint.Color = mutedColor(th.Fg)
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(material.Body2(th, "Daily target").Layout),
+				layout.Rigid(layout.Spacer{Width: 8}.Layout),
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Max.X = 60
+					gtx.Constraints.Min.X = 60
+					return editor{th, &hs.target, "None"}.layout(gtx)
+				}),
+				layout.Rigid(layout.Spacer{Width: 8}.Layout),
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Max.X = 120
+					gtx.Constraints.Min.X = 120
+					return editor{th, &hs.unit, "Unit (e.g. glasses)"}.layout(gtx)
+				}),
+			)
+		}),
+		layout.Rigid(layout.Spacer{Height: 6}.Layout),
+		layout.Rigid(hint.Layout),
+	)
+}
+
+// inputTarget returns the daily target entered for the new habit, which is
+// zero if none was entered.
+func (hs *habitScreen) inputTarget() (float64, error) {
+	txt := hs.target.Text()
+	if txt == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(txt, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a valid target", txt)
+	}
+	return n, nil
+}
+
+// inputSchedule returns the schedule currently described by the new habit's
+// schedule inputs.
+func (hs *habitScreen) inputSchedule() (schedule, error) {
+	switch hs.schedKind.Value {
+	case "weekdays":
+		return schedule{Kind: scheduleWeekdays, Weekdays: workWeek}, nil
+	case "days":
+		var days weekdaySet
+		for d := range hs.schedDays {
+			if hs.schedDays[d].Value {
+				days = days.with(time.Weekday(d))
+			}
+		}
+		if days == 0 {
+			return schedule{}, errors.New("no days of the week are selected")
+		}
+		return schedule{Kind: scheduleWeekdays, Weekdays: days}, nil
+	case "interval":
+		n, err := strconv.Atoi(hs.schedEvery.Text())
+		if err != nil || n < 1 {
+			return schedule{}, fmt.Errorf("%q is not a valid number of days", hs.schedEvery.Text())
+		}
+		return schedule{Kind: scheduleInterval, Interval: n}, nil
+	case "weekly":
+		n, err := strconv.Atoi(hs.schedTimes.Text())
+		if err != nil || n < 1 || n > 7 {
+			return schedule{}, fmt.Errorf("%q is not a valid number of times per week", hs.schedTimes.Text())
+		}
+		return schedule{Kind: scheduleWeekly, PerWeek: n}, nil
+	}
+	return schedule{}, nil
+}
+
+// habitDetail describes a habit's schedule and target, or returns an empty
+// string for a plain every day habit.
+func habitDetail(h *habit) string {
+	var parts []string
+	if h.Schedule.Kind != scheduleDaily {
+		parts = append(parts, h.Schedule.String())
+	}
+	if h.isQuantity() {
+		target := fmtQuantity(h.Target)
+		if h.Unit != "" {
+			target += " " + h.Unit
+		}
+		parts = append(parts, target)
+	}
+	return strings.Join(parts, ", ")
+}
+
 type applyHabitsToToday struct {
 	habits []habit
 }
 
 type closeHabitScreen struct{}
+
+// habitAdded is sent once a new habit has been given an ID, to add it to the
+// end of the list.
+type habitAdded struct {
+	habit habit
+}
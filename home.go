@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"strconv"
+	"strings"
 	"time"
 
 	"gioui.org/gesture"
@@ -18,8 +20,9 @@ import (
 )
 
 const (
-	cellWidth  = 29
-	cellHeight = 17
+	cellWidth     = 29
+	cellHeight    = 17
+	weekMarkWidth = 4
 )
 
 var monthAbbrevs = [12]string{
@@ -28,14 +31,23 @@ var monthAbbrevs = [12]string{
 }
 
 type homeScreen struct {
-	store      *store
-	updates    chan<- any
-	gridRows   []gridRow
-	editHabits widget.Clickable
-	habitList  widget.List
-	record     dailyRecordWidget
-	errors     errorList
-	invalidate func()
+	store    *store
+	updates  chan<- any
+	gridRows []gridRow
+	gridList layout.List
+	// hasOlder is set if there are days from before the grid's first row,
+	// which are loaded as it's scrolled back.
+	hasOlder     bool
+	loadingOlder bool
+	editHabits   widget.Clickable
+	showStats    widget.Clickable
+	exportCSV    widget.Clickable
+	habitList    widget.List
+	record       dailyRecordWidget
+	streaks      map[int]streak
+	notice       notice
+	errors       errorList
+	invalidate   func()
 }
 
 func (hs *homeScreen) layout(gtx C, th *material.Theme) D {
@@ -70,6 +82,9 @@ func (hs *homeScreen) layout(gtx C, th *material.Theme) D {
 				layout.Rigid(layout.Spacer{Height: 10}.Layout),
 				layout.Flexed(1, func(gtx C) D { return hs.layHabits(gtx, th) }),
 				layout.Rigid(layout.Spacer{Height: 10}.Layout),
+				layout.Rigid(func(gtx C) D {
+					return hs.notice.layout(gtx, th)
+				}),
 				layout.Rigid(func(gtx C) D {
 					return hs.errors.layout(gtx, th)
 				}),
@@ -87,7 +102,42 @@ func (hs *homeScreen) laySidebar(gtx C, th *material.Theme) D {
 				hs.invalidate()
 				return
 			}
-			hs.updates <- openHabitScreen{items}
+			streaks, err := hs.store.getStreaks()
+			if err != nil {
+				hs.errors.add("reading habit streaks", err)
+				hs.invalidate()
+				return
+			}
+			hs.updates <- openHabitScreen{items, streaks}
+		}()
+	}
+	if hs.showStats.Clicked() {
+		go func() {
+			today := dayOf(time.Now())
+			from := statsStart(today).Format(keyFormat)
+			templates, records, err := hs.store.getHistory(from, today.Format(keyFormat))
+			if err != nil {
+				hs.errors.add("reading habit history", err)
+				hs.invalidate()
+				return
+			}
+			hs.updates <- openStatsScreen{computeStats(templates, records, today)}
+		}()
+	}
+	if hs.exportCSV.Clicked() {
+		// Export the whole history, not just the weeks the grid has loaded.
+		go func() {
+			defer hs.invalidate()
+			fpath, err := hs.store.exportCSVFile("", todayKey())
+			if err != nil {
+				hs.errors.add("exporting CSV", err)
+				return
+			}
+			msg := "Exported to " + fpath
+			if hs.store.cipher() != nil {
+				msg += ". Unlike the database, the file isn't encrypted, so delete it once it's no longer needed."
+			}
+			hs.notice.set(msg)
 		}()
 	}
 	// Determine which month abbreviation takes up the most horizontal space
@@ -103,13 +153,21 @@ func (hs *homeScreen) laySidebar(gtx C, th *material.Theme) D {
 	}
 	outerInset := layout.Inset{Top: 5, Right: 12, Bottom: 5, Left: 5}
 	monthColInset := layout.Inset{Top: 2, Right: 5, Bottom: 2, Left: 2}
-	totalWidth := monthColWidth + 7*(4+cellWidth) + int(monthColInset.Left) + int(monthColInset.Right) + int(outerInset.Left) + int(outerInset.Right)
+	totalWidth := monthColWidth + 7*(4+cellWidth) + (4 + weekMarkWidth) + int(monthColInset.Left) + int(monthColInset.Right) + int(outerInset.Left) + int(outerInset.Right)
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
 		layout.Flexed(1, func(gtx C) D {
 			return outerInset.Layout(gtx, func(gtx C) D {
 				return hs.layDaySelection(gtx, th, monthColWidth, monthColInset)
 			})
 		}),
+		layout.Rigid(func(gtx C) D {
+			gtx.Constraints.Max.X = totalWidth
+			return sidebarButton(gtx, th, &hs.showStats, iconChart, "Statistics")
+		}),
+		layout.Rigid(func(gtx C) D {
+			gtx.Constraints.Max.X = totalWidth
+			return sidebarButton(gtx, th, &hs.exportCSV, iconExport, "Export CSV")
+		}),
 		layout.Rigid(func(gtx C) D {
 			gtx.Constraints.Max.X = totalWidth
 			return sidebarButton(gtx, th, &hs.editHabits, iconCheckCircle, "Manage Habits")
@@ -118,11 +176,10 @@ func (hs *homeScreen) laySidebar(gtx C, th *material.Theme) D {
 }
 
 func (hs *homeScreen) layDaySelection(gtx C, th *material.Theme, monthColWidth int, monthColInset layout.Inset) D {
-	now := time.Now()
-	flexRows := make([]layout.FlexChild, len(hs.gridRows)+1)
+	now := dayOf(time.Now())
 	// The first row in the navigation grid is for displaying the first letter
 	// of each day of the week.
-	flexRows[0] = layout.Rigid(func(gtx C) D {
+	letterRow := func(gtx C) D {
 		var letters [8]layout.FlexChild
 		// There will never be a month abbreviation on the same row as the
 		// weekday letters, so we just need to fill that space in here.
@@ -143,10 +200,10 @@ func (hs *homeScreen) layDaySelection(gtx C, th *material.Theme, monthColWidth i
 			})
 		}
 		return layout.Flex{}.Layout(gtx, letters[:]...)
-	})
-	for i := range hs.gridRows {
+	}
+	layRow := func(gtx C, i int) D {
 		r := &hs.gridRows[i]
-		var rowOfCells [8]layout.FlexChild
+		var rowOfCells [9]layout.FlexChild
 		// The first slot in the row is reserved for showing the month's
 		// abbreviation if this will be the first full row of the month.
 		rowOfCells[0] = layout.Rigid(func(gtx C) D {
@@ -194,11 +251,34 @@ func (hs *homeScreen) layDaySelection(gtx C, th *material.Theme, monthColWidth i
 				return layout.UniformInset(2).Layout(gtx, layCell)
 			})
 		}
-		flexRows[i+1] = layout.Rigid(func(gtx C) D {
-			return layout.Flex{Alignment: layout.Middle}.Layout(gtx, rowOfCells[:]...)
+		// The last slot in the row marks whether the week's goals were met.
+		rowOfCells[8] = layout.Rigid(func(gtx C) D {
+			return layout.UniformInset(2).Layout(gtx, func(gtx C) D {
+				if r.week.NumGoals == 0 {
+					return D{Size: image.Pt(weekMarkWidth, cellHeight)}
+				}
+				clr := color.NRGBA{80, 80, 80, 132}
+				if r.week.isMet() {
+					clr = color.NRGBA{79, 225, 255, 255}
+				}
+				return drawSquare(gtx, clr, weekMarkWidth, cellHeight)
+			})
 		})
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx, rowOfCells[:]...)
+	}
+	dims := layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(letterRow),
+		layout.Flexed(1, func(gtx C) D {
+			return hs.gridList.Layout(gtx, len(hs.gridRows), layRow)
+		}),
+	)
+	// Load older weeks once the grid is scrolled back to its first row (or
+	// doesn't fill the space it has).
+	if hs.gridList.Position.First == 0 && hs.hasOlder && !hs.loadingOlder {
+		hs.loadingOlder = true
+		go hs.loadOlderWeeks(hs.gridRows[0].cells[0].day)
 	}
-	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, flexRows...)
+	return dims
 }
 
 func sidebarButton(gtx C, th *material.Theme, click *widget.Clickable, ic *widget.Icon, txt string) D {
@@ -216,8 +296,16 @@ func sidebarButton(gtx C, th *material.Theme, click *widget.Clickable, ic *widge
 	})
 }
 
-func layItem(gtx C, th *material.Theme, check *widget.Bool, content string) D {
+func layItem(gtx C, th *material.Theme, check *widget.Bool, content, detail string) D {
 	lbl := material.Body1(th, content)
+	layDetail := func(gtx C) D {
+		if detail == "" {
+			return D{}
+		}
+		lbl := material.Caption(th, detail)
+		lbl.Color = mutedColor(th.Fg)
+		return layout.Inset{Left: 10}.Layout(gtx, lbl.Layout)
+	}
 	box := func(gtx C) D {
 		icon := iconUnchecked
 		if check.Value {
@@ -231,17 +319,54 @@ func layItem(gtx C, th *material.Theme, check *widget.Bool, content string) D {
 				layout.Rigid(box),
 				layout.Rigid(layout.Spacer{Width: 8}.Layout),
 				layout.Rigid(lbl.Layout),
+				layout.Rigid(layDetail),
 			)
 		})
 	})
 }
 
+func layQuantityItem(gtx C, th *material.Theme, in *habitInput, item *habit, detail string) D {
+	stepBtn := func(click *widget.Clickable, ic *widget.Icon, desc string) layout.Widget {
+		btn := material.IconButton(th, click, ic, desc)
+		btn.Size = 16
+		btn.Inset = layout.UniformInset(4)
+		return btn.Layout
+	}
+	progress := fmtQuantity(item.Value) + "/" + fmtQuantity(item.Target)
+	if item.Unit != "" {
+		progress += " " + item.Unit
+	}
+	if detail != "" {
+		progress += ", " + detail
+	}
+	progressLbl := material.Caption(th, progress)
+	progressLbl.Color = mutedColor(th.Fg)
+	return layout.Inset{Top: 5, Right: 20, Bottom: 5, Left: 20}.Layout(gtx, func(gtx C) D {
+		return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(stepBtn(&in.dec, iconRemove, "Decrease")),
+			layout.Rigid(func(gtx C) D {
+				gtx.Constraints.Min.X = 50
+				gtx.Constraints.Max.X = 50
+				return layout.Inset{Left: 6, Right: 6}.Layout(gtx, func(gtx C) D {
+					return editor{th, &in.value, "0"}.layout(gtx)
+				})
+			}),
+			layout.Rigid(stepBtn(&in.inc, iconAdd, "Increase")),
+			layout.Rigid(layout.Spacer{Width: 8}.Layout),
+			layout.Rigid(material.Body1(th, item.Content).Layout),
+			layout.Rigid(func(gtx C) D {
+				return layout.Inset{Left: 10}.Layout(gtx, progressLbl.Layout)
+			}),
+		)
+	})
+}
+
 func (hs *homeScreen) layHabits(gtx C, th *material.Theme) D {
 	if len(hs.record.habits) == 0 {
 		icon := iconWarning
 		clr := color.NRGBA{234, 180, 4, 255}
 		msg := material.Body1(th, "You didn't have any habits set on this day.").Layout
-		if hs.record.fmtDate == time.Now().Format("060102") {
+		if hs.record.fmtDate == todayKey() {
 			icon = iconInfo
 			clr = color.NRGBA{30, 180, 200, 255}
 			msg = func(gtx C) D {
@@ -273,20 +398,72 @@ func (hs *homeScreen) layHabits(gtx C, th *material.Theme) D {
 	}
 	return material.List(th, &hs.habitList).Layout(gtx, len(hs.record.habits), func(gtx C, i int) D {
 		item := &hs.record.habits[i]
-		check := &hs.record.checks[i]
-		if check.Changed() {
+		in := &hs.record.inputs[i]
+		var details []string
+		if item.Schedule.isWeeklyGoal() {
+			// Weekly goals stop being offered once they've been met on other days.
+			doneElsewhere := hs.record.weekCompl[item.ID]
+			if !item.isDone() && doneElsewhere >= item.Schedule.PerWeek {
+				return D{}
+			}
+			n := doneElsewhere
+			if item.isDone() {
+				n++
+			}
+			details = append(details, fmt.Sprintf("%d/%d this week", n, item.Schedule.PerWeek))
+		}
+		if st := hs.streaks[item.ID].String(); st != "" {
+			details = append(details, st)
+		}
+		detail := strings.Join(details, ", ")
+		if item.isQuantity() {
+			if hs.logQuantity(in, item) {
+				go hs.saveCurrentRecord(item.ID)
+				op.InvalidateOp{}.Add(gtx.Ops)
+			}
+			return layQuantityItem(gtx, th, in, item, detail)
+		}
+		if in.check.Changed() {
 			var t time.Time
-			if check.Value {
+			if in.check.Value {
 				t = time.Now()
 			}
 			item.CompletedAt = t
-			go hs.saveCurrentRecord()
+			go hs.saveCurrentRecord(item.ID)
 			op.InvalidateOp{}.Add(gtx.Ops)
 		}
-		return layItem(gtx, th, check, item.Content)
+		return layItem(gtx, th, &in.check, item.Content, detail)
 	})
 }
 
+// logQuantity applies any stepper clicks or typed amounts to the given
+// quantitative habit and reports whether its value changed.
+func (hs *homeScreen) logQuantity(in *habitInput, item *habit) bool {
+	v := item.Value
+	for in.dec.Clicked() {
+		v--
+	}
+	for in.inc.Clicked() {
+		v++
+	}
+	for _, e := range in.value.Events() {
+		if e, ok := e.(widget.SubmitEvent); ok {
+			n, err := strconv.ParseFloat(e.Text, 64)
+			if err != nil {
+				hs.errors.add("logging "+item.Content, fmt.Errorf("%q is not a number", e.Text))
+				continue
+			}
+			v = n
+		}
+	}
+	if v == item.Value {
+		return false
+	}
+	item.setValue(v, time.Now())
+	in.value.SetText(fmtQuantity(item.Value))
+	return true
+}
+
 func (hs *homeScreen) selectDay(fmtDate string) {
 	defer hs.invalidate()
 	items, err := hs.store.getHabitsForDay(fmtDate)
@@ -294,19 +471,103 @@ func (hs *homeScreen) selectDay(fmtDate string) {
 		hs.errors.add("selecting day", err)
 		return
 	}
-	hs.record, err = newDailyRecord(fmtDate, items)
+	weekCompl, err := hs.store.getWeekProgress(fmtDate)
+	if err != nil {
+		hs.errors.add("selecting day", err)
+		return
+	}
+	hs.record, err = newDailyRecord(fmtDate, items, weekCompl)
 	if err != nil {
 		hs.errors.add("selecting day", err)
 	}
 }
 
-func (hs *homeScreen) saveCurrentRecord() {
+// olderWeeks holds grid rows to add to the start of the grid.
+type olderWeeks struct {
+	err error
+	// before is the first day in the grid when the rows were loaded.
+	before   time.Time
+	rows     []gridRow
+	hasOlder bool
+}
+
+// loadOlderWeeks reads the `gridMonths` of weeks before the given day and
+// sends them back as `olderWeeks`.
+func (hs *homeScreen) loadOlderWeeks(before time.Time) {
+	u := olderWeeks{before: before}
+	end := before.AddDate(0, 0, -1)
+	u.rows, u.hasOlder, u.err = loadDayGrid(hs.store, gridStart(end), end)
+	hs.updates <- u
+}
+
+// prependWeeks adds older rows to the start of the grid, keeping the rows
+// that are in view where they are.
+func (hs *homeScreen) prependWeeks(u olderWeeks) {
+	hs.loadingOlder = false
+	if u.err != nil {
+		hs.hasOlder = false
+		hs.errors.add("reading older days", u.err)
+		return
+	}
+	// The grid may have been rebuilt since.
+	if !hs.gridRows[0].cells[0].day.Equal(u.before) {
+		return
+	}
+	hs.gridRows = append(u.rows, hs.gridRows...)
+	hs.gridList.Position.First += len(u.rows)
+	hs.hasOlder = u.hasOlder
+}
+
+// saveCurrentRecord saves the record being shown after the habit with the
+// given ID changed in it.
+func (hs *homeScreen) saveCurrentRecord(changedID int) {
 	defer hs.invalidate()
 	newSummary := newSummaryOfList(hs.record.habits)
 	hs.setSummary(hs.record.fmtDate, newSummary)
 	if err := hs.store.putHabitsForDay(hs.record.fmtDate, hs.record.habits); err != nil {
 		hs.errors.add("saving this day's habits", err)
+		return
+	}
+	if err := hs.refreshWeek(hs.record.fmtDate); err != nil {
+		hs.errors.add("reading this week's progress", err)
+	}
+	hs.refreshStreaks(changedID)
+}
+
+// refreshStreaks reloads the streaks of the habits with the given IDs, or of
+// every habit if none are given.
+func (hs *homeScreen) refreshStreaks(ids ...int) {
+	streaks, err := hs.store.getStreaks(ids...)
+	if err != nil {
+		hs.errors.add("reading habit streaks", err)
+		return
+	}
+	if len(ids) > 0 {
+		for id, st := range hs.streaks {
+			if _, ok := streaks[id]; !ok {
+				streaks[id] = st
+			}
+		}
 	}
+	hs.streaks = streaks
+}
+
+// refreshWeek reloads the weekly summary for the grid row containing the given date.
+func (hs *homeScreen) refreshWeek(fmtDate string) error {
+	summary, err := hs.store.getWeeklySummary(fmtDate)
+	if err != nil {
+		return err
+	}
+	for i := range hs.gridRows {
+		r := &hs.gridRows[i]
+		for j := range r.cells {
+			if r.cells[j].fmtDate == fmtDate {
+				r.week = summary
+				return nil
+			}
+		}
+	}
+	return nil
 }
 
 func (hs *homeScreen) setSummary(fmtDate string, summary dailySummary) {
@@ -324,6 +585,7 @@ func (hs *homeScreen) setSummary(fmtDate string, summary dailySummary) {
 type gridRow struct {
 	monthText string
 	cells     [7]gridCell
+	week      weeklySummary
 }
 
 type gridCell struct {
@@ -342,32 +604,73 @@ func (c *gridCell) Clicked(gtx C) bool {
 	return false
 }
 
-func newDayGrid(summaries map[string]dailySummary) []gridRow {
-	// Start date is six months ago, rounded back to the nearest Sunday,
-	// and the end date is today.
-	now := time.Now()
-	start := now.AddDate(0, -6, 0)
-	start = start.AddDate(0, 0, 0-int(start.Weekday()))
-	end := now
-
-	numRows := int(end.Sub(start).Hours()/24)/7 + 2
-	rows := make([]gridRow, 0, numRows)
-
-	for day := start; day.Before(end.AddDate(0, 0, 1)); {
-		var r gridRow
-		if day.Day() <= 7 {
-			r.monthText = monthAbbrevs[day.Month()-1]
-		}
-		for i := 0; i < 7; i++ {
-			fmtDate := day.Format("060102")
-			r.cells[i] = gridCell{
-				day:     day,
-				fmtDate: fmtDate,
-				summary: summaries[fmtDate],
-			}
-			day = day.AddDate(0, 0, 1)
+// gridMonths is how many months of weeks the grid shows at first, and how
+// many more it loads at a time when scrolled back.
+const gridMonths = 6
+
+// gridStart returns the Sunday that begins the grid when it ends with the
+// week containing `end`.
+func gridStart(end time.Time) time.Time {
+	start := end.AddDate(0, -gridMonths, 0)
+	return weekStart(start)
+}
+
+// loadDayGrid reads the summaries for the weeks from the one starting on
+// `start` through the one containing `end` and returns their grid rows, along
+// with whether there are any days before them.
+func loadDayGrid(s *store, start, end time.Time) (rows []gridRow, hasOlder bool, _ error) {
+	from := start.Format(keyFormat)
+	to := weekStart(end).AddDate(0, 0, 6).Format(keyFormat)
+	summaries, err := s.getSummaries(from, to)
+	if err != nil {
+		return nil, false, err
+	}
+	weeklies, err := s.getWeeklySummaries(from, to)
+	if err != nil {
+		return nil, false, err
+	}
+	hasOlder, err = s.hasRecordsBefore(from)
+	if err != nil {
+		return nil, false, err
+	}
+	return newDayGrid(start, end, summaries, weeklies), hasOlder, nil
+}
+
+// newDayGrid returns the rows for the weeks from the one starting on `start`
+// through the one containing `end`.
+func newDayGrid(start, end time.Time, summaries map[string]dailySummary, weeklies map[string]weeklySummary) []gridRow {
+	rows := make([]gridRow, 0, daysBetween(start, end)/7+1)
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 7) {
+		rows = append(rows, newGridRow(day, summaries, weeklies))
+	}
+	return rows
+}
+
+// newGridRow returns the row for the week starting on the given Sunday.
+func newGridRow(day time.Time, summaries map[string]dailySummary, weeklies map[string]weeklySummary) gridRow {
+	var r gridRow
+	if day.Day() <= 7 {
+		r.monthText = monthAbbrevs[day.Month()-1]
+	}
+	r.week = weeklies[day.Format(keyFormat)]
+	for i := 0; i < 7; i++ {
+		fmtDate := day.Format(keyFormat)
+		r.cells[i] = gridCell{
+			day:     day,
+			fmtDate: fmtDate,
+			summary: summaries[fmtDate],
 		}
-		rows = append(rows, r)
+		day = day.AddDate(0, 0, 1)
+	}
+	return r
+}
+
+// extendDayGrid adds empty rows to the end of the grid until it reaches the
+// week containing `end`.
+func extendDayGrid(rows []gridRow, end time.Time) []gridRow {
+	day := rows[len(rows)-1].cells[6].day.AddDate(0, 0, 1)
+	for ; !day.After(end); day = day.AddDate(0, 0, 7) {
+		rows = append(rows, newGridRow(day, nil, nil))
 	}
 	return rows
 }
@@ -383,15 +686,20 @@ type dailyRecordWidget struct {
 	fmtDate    string
 	prettyDate string
 	habits     []habit
-	checks     []widget.Bool
+	inputs     []habitInput
+	// weekCompl holds how many times each weekly goal habit was done on the
+	// other days of this record's week.
+	weekCompl map[int]int
 }
 
-func newDailyRecord(fmtDate string, habits []habit) (dailyRecordWidget, error) {
-	checks := make([]widget.Bool, len(habits))
+func newDailyRecord(fmtDate string, habits []habit, weekCompl map[int]int) (dailyRecordWidget, error) {
+	inputs := make([]habitInput, len(habits))
 	for i := range habits {
-		checks[i] = widget.Bool{Value: habits[i].isDone()}
+		inputs[i].check.Value = habits[i].isDone()
+		inputs[i].value = widget.Editor{SingleLine: true, Submit: true, Filter: "0123456789."}
+		inputs[i].value.SetText(fmtQuantity(habits[i].Value))
 	}
-	t, err := time.ParseInLocation("060102", fmtDate, time.Now().Location())
+	t, err := parseKey(fmtDate)
 	if err != nil {
 		return dailyRecordWidget{}, fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
 	}
@@ -399,10 +707,21 @@ func newDailyRecord(fmtDate string, habits []habit) (dailyRecordWidget, error) {
 		fmtDate:    fmtDate,
 		prettyDate: t.Format("Jan 2, 2006"),
 		habits:     habits,
-		checks:     checks,
+		inputs:     inputs,
+		weekCompl:  weekCompl,
 	}, nil
 }
 
+// habitInput holds the widget state for logging progress on one of a day's
+// habits: a checkbox for simple habits or a stepper for quantitative ones.
+type habitInput struct {
+	check widget.Bool
+	dec   widget.Clickable
+	inc   widget.Clickable
+	value widget.Editor
+}
+
 type openHabitScreen struct {
-	items []habit
+	items   []habit
+	streaks map[int]streak
 }
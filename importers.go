@@ -0,0 +1,609 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// importedHabit is a habit read from another habit tracker along with its history.
+type importedHabit struct {
+	name     string
+	schedule schedule
+	target   float64
+	unit     string
+	archived bool
+	// entries holds the habit's history by date key. A day only has an entry
+	// if the habit was due on it.
+	entries map[string]importedEntry
+}
+
+type importedEntry struct {
+	done  bool
+	value float64
+}
+
+// mergeImported adds habits imported from another tracker to the end of the
+// template list and their history to the daily records, and then rebuilds the
+// summaries. Each habit is given a creation date of its first entry so that
+// the days between its entries still come out right. A habit that's already
+// in the template list under the same name and schedule, such as one from an
+// earlier import of the same file, has the history merged into it instead. It
+// returns how many of the habits were merged that way.
+func (s *store) mergeImported(imported []importedHabit) (merged int, _ error) {
+	now := time.Now()
+	today := todayKey()
+	return merged, s.db.Update(func(tx *bbolt.Tx) error {
+		meta := bucketOf(tx, "meta")
+		dailys := bucketOf(tx, "dailyRecords")
+		var templates []habit
+		if err := get(meta, []byte("habits"), &templates); err != nil {
+			return fmt.Errorf("reading habit template list: %w", err)
+		}
+		sortHabits(templates, templates)
+		existing := append([]habit(nil), templates...)
+		additions := make(map[string][]habit)
+		for _, ih := range imported {
+			var first, last string
+			for k := range ih.entries {
+				if k > today {
+					continue
+				}
+				if first == "" || k < first {
+					first = k
+				}
+				if k > last {
+					last = k
+				}
+			}
+			var firstDay time.Time
+			if first != "" {
+				t, err := parseKey(first)
+				if err != nil {
+					return fmt.Errorf("parsing fmtDate %q: %w", first, err)
+				}
+				firstDay = startOfDay(t)
+			}
+			i := findImported(templates, &ih)
+			if i >= 0 {
+				merged++
+				if !firstDay.IsZero() && firstDay.Before(templates[i].CreatedAt) {
+					templates[i].CreatedAt = createdBy(&templates[i], firstDay)
+				}
+			} else {
+				seq, err := meta.NextSequence()
+				if err != nil {
+					return fmt.Errorf("getting next habit ID: %w", err)
+				}
+				h := habit{
+					ID:        int(seq),
+					CreatedAt: now,
+					Content:   ih.name,
+					Schedule:  ih.schedule,
+					Target:    ih.target,
+					Unit:      ih.unit,
+					Pos:       len(templates),
+				}
+				if !firstDay.IsZero() {
+					h.CreatedAt = firstDay
+				}
+				if ih.archived {
+					h.DeletedAt = now
+					if last != "" {
+						t, err := parseKey(last)
+						if err != nil {
+							return fmt.Errorf("parsing fmtDate %q: %w", last, err)
+						}
+						h.DeletedAt = t.AddDate(0, 0, 1)
+					}
+				}
+				templates = append(templates, h)
+				i = len(templates) - 1
+			}
+			for k, e := range ih.entries {
+				if k > today {
+					continue
+				}
+				t, err := parseKey(k)
+				if err != nil {
+					return fmt.Errorf("parsing fmtDate %q: %w", k, err)
+				}
+				item := templates[i]
+				item.DeletedAt = time.Time{}
+				item.CreatedAt = t
+				if item.isQuantity() {
+					item.setValue(e.value, t)
+				} else if e.done {
+					item.CompletedAt = t
+				}
+				additions[k] = append(additions[k], item)
+			}
+		}
+		for k, items := range additions {
+			// A day without a record yet gets the habits that would have been
+			// on it anyway before the imported ones are added.
+			var dayItems []habit
+			if dailys.Get([]byte(k)) == nil {
+				t, err := parseKey(k)
+				if err != nil {
+					return fmt.Errorf("parsing fmtDate %q: %w", k, err)
+				}
+				dayItems = newDayItems(existing, t, now)
+			} else if err := get(dailys, []byte(k), &dayItems); err != nil {
+				return fmt.Errorf("getting habits for %q: %w", k, err)
+			}
+			if err := put(dailys, []byte(k), mergeDayItems(dayItems, items)); err != nil {
+				return fmt.Errorf("writing habits for %q: %w", k, err)
+			}
+		}
+		if err := put(meta, []byte("habits"), templates); err != nil {
+			return fmt.Errorf("putting habit template list into meta: %w", err)
+		}
+		return rebuildSummaries(tx)
+	})
+}
+
+// findImported returns the index of the template habit with the same name
+// and schedule as the imported habit `ih`, or -1 if there isn't one.
+func findImported(templates []habit, ih *importedHabit) int {
+	for i := range templates {
+		if templates[i].Content == ih.name && templates[i].Schedule == ih.schedule {
+			return i
+		}
+	}
+	return -1
+}
+
+// createdBy returns the latest creation date no later than `day` for
+// template habit `h`. An interval habit keeps being due on the same days.
+func createdBy(h *habit, day time.Time) time.Time {
+	if h.Schedule.Kind != scheduleInterval || h.Schedule.Interval <= 1 {
+		return day
+	}
+	created := h.CreatedAt
+	for day.Before(created) {
+		created = created.AddDate(0, 0, -h.Schedule.Interval)
+	}
+	return created
+}
+
+// mergeDayItems adds imported habits to a day's items. A habit that's already
+// on the day only takes on the imported progress if none has been made on it.
+func mergeDayItems(dayItems, imported []habit) []habit {
+	for _, item := range imported {
+		j := 0
+		for j < len(dayItems) && dayItems[j].ID != item.ID {
+			j++
+		}
+		switch {
+		case j == len(dayItems):
+			dayItems = append(dayItems, item)
+		case !dayItems[j].isDone() && dayItems[j].Value == 0:
+			dayItems[j] = item
+		}
+	}
+	return dayItems
+}
+
+// readLoopExport reads the habits and their history out of a CSV export or a
+// database backup from Loop Habit Tracker. A CSV export can either be the zip
+// file Loop creates or a directory it was extracted into.
+func readLoopExport(fpath string) ([]importedHabit, error) {
+	info, err := os.Stat(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return readLoopCSV(os.DirFS(fpath))
+	}
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, sqliteMagic) {
+		return readLoopBackup(data)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s as a zip file: %w", fpath, err)
+	}
+	return readLoopCSV(zr)
+}
+
+// readLoopCSV reads a Loop CSV export, which needs the `Habits.csv` and
+// `Checkmarks.csv` files.
+//
+// In `Checkmarks.csv`, a 2 means the habit was done, 1 means it wasn't needed
+// that day to keep up with its frequency, 0 means it was missed, 3 means it
+// was skipped and -1 means there's no data for that day. Only 2 and 0 are
+// imported, since the other days weren't due. Numerical habits have their
+// values stored in thousandths.
+func readLoopCSV(fsys fs.FS) ([]importedHabit, error) {
+	dir, err := findFileDir(fsys, "Habits.csv")
+	if err != nil {
+		return nil, err
+	}
+	habitRows, err := readCSVFromFS(fsys, path.Join(dir, "Habits.csv"))
+	if err != nil {
+		return nil, err
+	}
+	checkRows, err := readCSVFromFS(fsys, path.Join(dir, "Checkmarks.csv"))
+	if err != nil {
+		return nil, err
+	}
+	if len(habitRows) == 0 || len(checkRows) == 0 {
+		return nil, errors.New("the Loop export has no habits")
+	}
+
+	cols := make(map[string]int)
+	for i, name := range habitRows[0] {
+		cols[strings.TrimSpace(name)] = i
+	}
+	field := func(row []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := cols[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+		}
+		return ""
+	}
+	if _, ok := cols["Name"]; !ok {
+		return nil, errors.New("Habits.csv has no Name column")
+	}
+	var habits []importedHabit
+	var numerical []bool
+	for _, row := range habitRows[1:] {
+		ih := importedHabit{
+			name:     field(row, "Name"),
+			archived: isTruthy(field(row, "Archived?", "Archived")),
+			unit:     field(row, "Unit"),
+			entries:  make(map[string]importedEntry),
+		}
+		num, _ := strconv.Atoi(field(row, "FrequencyNumerator", "NumRepetitions"))
+		den, _ := strconv.Atoi(field(row, "FrequencyDenominator", "Interval"))
+		ih.schedule = loopSchedule(num, den)
+		typ := field(row, "Type")
+		isNumerical := typ == "1" || strings.EqualFold(typ, "NUMERICAL")
+		if isNumerical {
+			ih.target, _ = strconv.ParseFloat(field(row, "Target Value", "TargetValue"), 64)
+			if ih.target <= 0 {
+				ih.target = 1
+			}
+		}
+		habits = append(habits, ih)
+		numerical = append(numerical, isNumerical)
+	}
+
+	// The checkmark columns are in the same order as the habits.
+	header := checkRows[0]
+	if len(header)-1 != len(habits) {
+		return nil, fmt.Errorf("Checkmarks.csv has %d habit columns but Habits.csv has %d habits", len(header)-1, len(habits))
+	}
+	for _, row := range checkRows[1:] {
+		if len(row) == 0 || row[0] == "" {
+			continue
+		}
+		t, err := parseDate(strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Checkmarks.csv: invalid date %q: %w", row[0], err)
+		}
+		k := t.Format(keyFormat)
+		for i := 1; i < len(row) && i <= len(habits); i++ {
+			v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				continue
+			}
+			if numerical[i-1] {
+				if v < 0 {
+					continue
+				}
+				habits[i-1].entries[k] = importedEntry{value: v / 1000}
+				continue
+			}
+			switch v {
+			case 2:
+				habits[i-1].entries[k] = importedEntry{done: true}
+			case 0:
+				habits[i-1].entries[k] = importedEntry{}
+			}
+		}
+	}
+	return habits, nil
+}
+
+// loopSchedule converts a Loop habit's frequency of `num` times every `den`
+// days into the closest schedule.
+func loopSchedule(num, den int) schedule {
+	switch {
+	case num <= 0 || den <= 1 || num >= den:
+		return schedule{}
+	case num == 1:
+		return schedule{Kind: scheduleInterval, Interval: den}
+	case den == 7:
+		return schedule{Kind: scheduleWeekly, PerWeek: num}
+	}
+	// Something like 4 times in 10 days becomes 3 times a week.
+	perWeek := (num*7 + den/2) / den
+	if perWeek < 1 {
+		perWeek = 1
+	}
+	return schedule{Kind: scheduleWeekly, PerWeek: perWeek}
+}
+
+// readLoopBackup reads a Loop database backup. Unlike a CSV export, the
+// backup only has the days that were marked in its `Repetitions` table, with
+// the same values as in `Checkmarks.csv`, so the days a habit was due but not
+// marked are filled in as misses.
+func readLoopBackup(data []byte) ([]importedHabit, error) {
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading the Loop backup: %w", err)
+	}
+	habitRows, err := db.readTable("Habits")
+	if err != nil {
+		return nil, fmt.Errorf("reading the Loop backup: %w", err)
+	}
+	repRows, err := db.readTable("Repetitions")
+	if err != nil {
+		return nil, fmt.Errorf("reading the Loop backup: %w", err)
+	}
+	sort.SliceStable(habitRows, func(i, j int) bool {
+		return habitRows[i].int("position") < habitRows[j].int("position")
+	})
+	habits := make([]importedHabit, 0, len(habitRows))
+	numerical := make([]bool, 0, len(habitRows))
+	byID := make(map[int64]int, len(habitRows))
+	for _, row := range habitRows {
+		ih := importedHabit{
+			name:     row.string("name"),
+			archived: row.int("archived") != 0,
+			unit:     row.string("unit"),
+			schedule: loopSchedule(int(row.int("freq_num")), int(row.int("freq_den"))),
+			entries:  make(map[string]importedEntry),
+		}
+		isNumerical := row.int("type") == 1
+		if isNumerical {
+			ih.target = row.float("target_value")
+			if ih.target <= 0 {
+				ih.target = 1
+			}
+		}
+		byID[row.int("id")] = len(habits)
+		habits = append(habits, ih)
+		numerical = append(numerical, isNumerical)
+	}
+
+	// Timestamps are the milliseconds to midnight UTC at the start of the day.
+	marked := make([]map[string]bool, len(habits))
+	firsts := make([]time.Time, len(habits))
+	lasts := make([]time.Time, len(habits))
+	for _, row := range repRows {
+		i, ok := byID[row.int("habit")]
+		if !ok {
+			continue
+		}
+		t := time.UnixMilli(row.int("timestamp")).UTC()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if firsts[i].IsZero() || day.Before(firsts[i]) {
+			firsts[i] = day
+		}
+		if day.After(lasts[i]) {
+			lasts[i] = day
+		}
+		if marked[i] == nil {
+			marked[i] = make(map[string]bool)
+		}
+		k := day.Format(keyFormat)
+		marked[i][k] = true
+		v := row.float("value")
+		if numerical[i] {
+			if v >= 0 {
+				habits[i].entries[k] = importedEntry{value: v / 1000}
+			}
+			continue
+		}
+		switch v {
+		case 2:
+			habits[i].entries[k] = importedEntry{done: true}
+		case 0:
+			habits[i].entries[k] = importedEntry{}
+		}
+	}
+
+	y, m, d := dayOf(time.Now()).Date()
+	yesterday := time.Date(y, m, d-1, 0, 0, 0, 0, time.UTC)
+	for i := range habits {
+		if firsts[i].IsZero() {
+			continue
+		}
+		last := yesterday
+		if habits[i].archived {
+			last = lasts[i]
+		}
+		habits[i].fillMisses(firsts[i], last, marked[i])
+	}
+	return habits, nil
+}
+
+// fillMisses adds a miss for each day from `first` through `last` that isn't
+// in `marked` and that the habit would have been due on, counting `first` as
+// the day it was created. The days should be midnight UTC.
+func (ih *importedHabit) fillMisses(first, last time.Time, marked map[string]bool) {
+	var week time.Time
+	var doneInWeek int
+	for t := first; !t.After(last); t = t.AddDate(0, 0, 1) {
+		if ws := weekStart(t); !ws.Equal(week) {
+			week, doneInWeek = ws, 0
+		}
+		k := t.Format(keyFormat)
+		if marked[k] {
+			if e, ok := ih.entries[k]; ok && (e.done || ih.target > 0 && e.value >= ih.target) {
+				doneInWeek++
+			}
+			continue
+		}
+		switch ih.schedule.Kind {
+		case scheduleInterval:
+			if n := daysBetween(first, t); ih.schedule.Interval > 1 && n%ih.schedule.Interval != 0 {
+				continue
+			}
+		case scheduleWeekly:
+			if doneInWeek >= ih.schedule.PerWeek {
+				continue
+			}
+		}
+		ih.entries[k] = importedEntry{}
+	}
+}
+
+// findFileDir returns the directory within `fsys` that contains a file with
+// the given name.
+func findFileDir(fsys fs.FS, name string) (string, error) {
+	var dir string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || dir != "" {
+			return err
+		}
+		if !d.IsDir() && d.Name() == name {
+			dir = path.Dir(p)
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return "", fmt.Errorf("no %s found", name)
+	}
+	return dir, nil
+}
+
+func readCSVFromFS(fsys fs.FS, name string) ([][]string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCSV(f, name)
+}
+
+func readCSV(r io.Reader, name string) ([][]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	return rows, nil
+}
+
+// readSimpleCSV reads a CSV file of "date,habit,done" rows, with or without a
+// header row. Dates are YYYY-MM-DD and the done column can be things like
+// 1/0, true/false, yes/no or x/blank. Habits are listed in the order they first
+// appear.
+func readSimpleCSV(fpath string) ([]importedHabit, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := readCSV(f, fpath)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "date") {
+		rows = rows[1:]
+	}
+	var habits []importedHabit
+	byName := make(map[string]int)
+	for n, row := range rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("line %d: expected date, habit and done columns", n+1)
+		}
+		t, err := parseDate(strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", n+1, row[0])
+		}
+		name := strings.TrimSpace(row[1])
+		if name == "" {
+			return nil, fmt.Errorf("line %d: blank habit", n+1)
+		}
+		done, ok := parseDone(row[2])
+		if !ok {
+			return nil, fmt.Errorf("line %d: can't tell if %q means done or not", n+1, row[2])
+		}
+		i, ok := byName[name]
+		if !ok {
+			i = len(habits)
+			byName[name] = i
+			habits = append(habits, importedHabit{name: name, entries: make(map[string]importedEntry)})
+		}
+		habits[i].entries[t.Format(keyFormat)] = importedEntry{done: done}
+	}
+	return habits, nil
+}
+
+func parseDone(s string) (done, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "x", "done", "✓", "✔":
+		return true, true
+	case "0", "false", "no", "n", "", "-":
+		return false, true
+	}
+	return false, false
+}
+
+func isTruthy(s string) bool {
+	done, ok := parseDone(s)
+	return ok && done
+}
+
+func runImportLoop(env *cmdEnv, args []string) error {
+	return runImporter(env, "import-loop", args, readLoopExport)
+}
+
+func runImportCSV(env *cmdEnv, args []string) error {
+	return runImporter(env, "import-csv", args, readSimpleCSV)
+}
+
+func runImporter(env *cmdEnv, name string, args []string, read func(fpath string) ([]importedHabit, error)) error {
+	flags, dbFile := newCommandFlags(env, name)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageError(flags)
+	}
+	habits, err := read(env.path(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	return env.withStore(*dbFile, func(s *store) error {
+		merged, err := s.mergeImported(habits)
+		if err != nil {
+			return err
+		}
+		numEntries := 0
+		for _, h := range habits {
+			numEntries += len(h.entries)
+		}
+		fmt.Fprintf(env.stdout, "Imported %d habits with %d entries.\n", len(habits), numEntries)
+		if merged > 0 {
+			fmt.Fprintf(env.stdout, "%d of them were already there and had their history merged.\n", merged)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeImportedTwice(t *testing.T) {
+	s, err := openStore(filepath.Join(t.TempDir(), "db.todaily"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	today := dayOf(time.Now())
+	entries := make(map[string]importedEntry)
+	for i := 1; i <= 5; i++ {
+		entries[today.AddDate(0, 0, -i).Format(keyFormat)] = importedEntry{done: i%2 == 0}
+	}
+	imported := []importedHabit{{name: "Read", entries: entries}}
+
+	for i, want := range []int{0, 1} {
+		merged, err := s.mergeImported(imported)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged != want {
+			t.Errorf("import %d merged %d habits, want %d", i+1, merged, want)
+		}
+	}
+
+	templates, records, err := s.getHistory("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 {
+		t.Fatalf("got %d templates, want 1", len(templates))
+	}
+	for k, e := range entries {
+		items := records[k]
+		if len(items) != 1 || items[0].ID != templates[0].ID {
+			t.Errorf("%s has %+v, want just the imported habit", k, items)
+			continue
+		}
+		if items[0].isDone() != e.done {
+			t.Errorf("%s: done is %v, want %v", k, items[0].isDone(), e.done)
+		}
+	}
+	problems, err := s.checkIntegrity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("unexpected problem: %s", p)
+	}
+}
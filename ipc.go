@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// While the app has a database open, nothing else can open it. So the app
+// listens on a Unix domain socket beside the database (see
+// `controlSocketPath`) and commands run from the command line are forwarded
+// to it, running against the app's own store. So are the requests made to
+// `todaily serve` (see serve.go). Each connection carries one JSON
+// `controlRequest` answered by one JSON `controlResponse`.
+
+const controlTimeout = 30 * time.Second
+
+type controlRequest struct {
+	// Args is the command line, starting with the command's name.
+	Args []string `json:"args"`
+	// Dir is the working directory of the command line.
+	Dir string `json:"dir"`
+	// Raise asks the app to bring its window to the front instead of running
+	// a command.
+	Raise bool `json:"raise,omitempty"`
+	// API is a request to the JSON API to answer instead of running a command.
+	API *controlAPIRequest `json:"api,omitempty"`
+}
+
+// controlAPIRequest is an HTTP request made to `todaily serve`.
+type controlAPIRequest struct {
+	Method string `json:"method"`
+	// URL is the request's path and query.
+	URL         string `json:"url"`
+	Host        string `json:"host"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// controlAPIResponse is the app's answer to a `controlAPIRequest`.
+type controlAPIResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+type controlResponse struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	Err    string `json:"err,omitempty"`
+	// Usage is set if the command was given invalid arguments.
+	Usage bool `json:"usage,omitempty"`
+	// API is the answer to an API request.
+	API *controlAPIResponse `json:"api,omitempty"`
+}
+
+// controlSocketPath returns the path of the socket for the database at
+// `dbPath`. It's in a directory that only the user can get into, so that the
+// socket is never reachable by others, even before its own permissions can
+// be set.
+func controlSocketPath(dbPath string) string {
+	return filepath.Join(dbPath+".control", "sock")
+}
+
+// dialControl connects to the app if it has the database at `dbPath` open.
+func dialControl(dbPath string) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", controlSocketPath(dbPath), time.Second)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	return conn, nil
+}
+
+// forwardCommand runs the given command line in the app if it has the given
+// database open, writing out the command's output. It reports whether the
+// command was forwarded.
+func forwardCommand(dbFile string, args []string) (bool, error) {
+	dbPath, err := resolveDBPath(dbFile)
+	if err != nil {
+		return false, nil
+	}
+	conn, err := dialControl(dbPath)
+	if err != nil {
+		// Nothing is listening, so the database can be opened directly.
+		return false, nil
+	}
+	defer conn.Close()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return true, err
+	}
+	if err := json.NewEncoder(conn).Encode(controlRequest{Args: args, Dir: dir}); err != nil {
+		return true, fmt.Errorf("sending command to the open Todaily window: %w", err)
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return true, fmt.Errorf("reading the open Todaily window's response: %w", err)
+	}
+	os.Stdout.WriteString(resp.Stdout)
+	os.Stderr.WriteString(resp.Stderr)
+	switch {
+	case resp.Usage:
+		return true, errUsage
+	case resp.Err != "":
+		return true, errors.New(resp.Err)
+	}
+	return true, nil
+}
+
+// raiseRunningWindow asks the app that has the given database open to bring
+// its window to the front.
+func raiseRunningWindow(dbPath string) error {
+	conn, err := dialControl(dbPath)
+	if err != nil {
+		return fmt.Errorf("couldn't reach it, it may be a command rather than a window: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(controlRequest{Raise: true}); err != nil {
+		return err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if resp.Err != "" {
+		return errors.New(resp.Err)
+	}
+	return nil
+}
+
+// forwardAPIRequest has the app answer an API request if it has the database
+// at `dbPath` open. It reports whether the request was forwarded.
+func forwardAPIRequest(dbPath string, req *controlAPIRequest) (*controlAPIResponse, bool, error) {
+	conn, err := dialControl(dbPath)
+	if err != nil {
+		return nil, false, nil
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(controlRequest{API: req}); err != nil {
+		return nil, true, fmt.Errorf("sending request to the open Todaily window: %w", err)
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, true, fmt.Errorf("reading the open Todaily window's response: %w", err)
+	}
+	if resp.API == nil {
+		return nil, true, errors.New("the open Todaily window can't answer API requests")
+	}
+	return resp.API, true, nil
+}
+
+// listenControl starts listening for forwarded commands for the given store.
+func listenControl(s *store) (net.Listener, error) {
+	fpath := controlSocketPath(s.db.Path())
+	dir := filepath.Dir(fpath)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating control socket directory: %w", err)
+	}
+	// The directory may have been made by someone else with other permissions.
+	if err := os.Chmod(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("restricting control socket directory: %w", err)
+	}
+	// Any socket that's already there was left behind, since this process
+	// holds the database's lock.
+	if err := os.Remove(fpath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("removing stale control socket: %w", err)
+	}
+	l, err := net.Listen("unix", fpath)
+	if err != nil {
+		return nil, fmt.Errorf("listening on control socket: %w", err)
+	}
+	if err := os.Chmod(fpath, 0o600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("restricting control socket: %w", err)
+	}
+	return l, nil
+}
+
+// serveControl runs the commands and API requests forwarded over `l` against
+// the given store until `l` is closed, sending a `storeChanged` message after
+// each one that succeeds and may have changed the database.
+func serveControl(l net.Listener, s *store, updates chan<- any) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("accepting on control socket: %v", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(controlTimeout))
+			var req controlRequest
+			if err := json.NewDecoder(conn).Decode(&req); err != nil {
+				log.Printf("reading forwarded command: %v", err)
+				return
+			}
+			if req.Raise {
+				updates <- raiseWindow{}
+				json.NewEncoder(conn).Encode(controlResponse{})
+				return
+			}
+			if req.API != nil {
+				resp := serveForwardedAPI(s, req.API)
+				if err := json.NewEncoder(conn).Encode(controlResponse{API: resp}); err != nil {
+					log.Printf("answering forwarded API request: %v", err)
+				}
+				if req.API.Method != http.MethodGet && resp.Status < 400 {
+					updates <- storeChanged{}
+				}
+				return
+			}
+			resp, readOnly := runForwarded(s, &req)
+			if err := json.NewEncoder(conn).Encode(resp); err != nil {
+				log.Printf("answering forwarded command: %v", err)
+			}
+			if resp.Err == "" && !resp.Usage && !readOnly {
+				updates <- storeChanged{}
+			}
+		}()
+	}
+}
+
+// runForwarded runs a forwarded command and also reports whether it's one
+// that never changes the database.
+func runForwarded(s *store, req *controlRequest) (_ controlResponse, readOnly bool) {
+	if len(req.Args) == 0 {
+		return controlResponse{Err: "no command given"}, true
+	}
+	cmd, ok := commands()[req.Args[0]]
+	if !ok {
+		return controlResponse{Err: fmt.Sprintf("unknown command %q", req.Args[0])}, true
+	}
+	if cmd.local {
+		return controlResponse{Err: "can't be run while the database is open in Todaily"}, true
+	}
+	var stdout, stderr bytes.Buffer
+	env := cmdEnv{stdout: &stdout, stderr: &stderr, dir: req.Dir, store: s}
+	err := cmd.run(&env, req.Args[1:])
+	resp := controlResponse{Stdout: stdout.String(), Stderr: stderr.String()}
+	switch {
+	case errors.Is(err, errUsage):
+		resp.Usage = true
+	case err != nil && !errors.Is(err, flag.ErrHelp):
+		resp.Err = err.Error()
+	}
+	return resp, cmd.readOnly
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"image"
 	"image/color"
 	"log"
+	"net"
 	"os"
 	"time"
 
@@ -15,6 +18,7 @@ import (
 	"gioui.org/op"
 	"gioui.org/op/clip"
 	"gioui.org/op/paint"
+	"gioui.org/text"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 	"github.com/steverusso/gio-fonts/vegur"
@@ -31,6 +35,15 @@ type habit struct {
 	CompletedAt time.Time `json:"compl,omitempty"`
 	DeletedAt   time.Time `json:"del,omitempty"`
 	Content     string    `json:"cont,omitempty"`
+	Schedule    schedule  `json:"sched"`
+	// Target is the amount that has to be logged each day for a quantitative
+	// habit to count as done. It's zero for habits that are simply checked off.
+	Target float64 `json:"tgt,omitempty"`
+	Unit   string  `json:"unit,omitempty"`
+	Value  float64 `json:"val,omitempty"`
+	// Pos is the habit's position in the template list, which is the order
+	// habits are shown in.
+	Pos int `json:"pos"`
 }
 
 func (h *habit) isDone() bool {
@@ -41,29 +54,135 @@ func (h *habit) isDeleted() bool {
 	return !h.DeletedAt.IsZero()
 }
 
+// isDeletedBy reports whether the habit had been deleted by the time the day
+// starting at midnight `day` began.
+func (h *habit) isDeletedBy(day time.Time) bool {
+	return h.isDeleted() && !h.DeletedAt.After(startOfDay(day))
+}
+
+func (h *habit) isQuantity() bool {
+	return h.Target > 0
+}
+
+// credit returns how much of this habit has been done, from zero to one.
+// Quantitative habits get partial credit for partial progress.
+func (h *habit) credit() float32 {
+	if h.isQuantity() && !h.isDone() {
+		return float32(h.Value / h.Target)
+	}
+	if h.isDone() {
+		return 1
+	}
+	return 0
+}
+
+// setValue logs the amount done for a quantitative habit, marking it done
+// once the target is reached.
+func (h *habit) setValue(v float64, now time.Time) {
+	if v < 0 {
+		v = 0
+	}
+	h.Value = v
+	if v < h.Target {
+		h.CompletedAt = time.Time{}
+	} else if !h.isDone() {
+		h.CompletedAt = now
+	}
+}
+
+// isActiveOn reports whether this template habit should be part of the
+// record for the day starting at midnight `t`.
+func (h *habit) isActiveOn(t time.Time) bool {
+	if !h.CreatedAt.Before(startOfDay(t)) || h.isDeletedBy(t) {
+		return false
+	}
+	return h.Schedule.isDue(t, h.CreatedAt)
+}
+
+// mergeTemplate rebuilds a day's list of habits from the template list so that
+// any new, edited or deleted habits are reflected, while keeping the progress
+// already made on the day's existing habits.
+func mergeTemplate(templates, dayItems []habit, now time.Time) []habit {
+	var resolved []habit
+	for _, tmplHabit := range templates {
+		if tmplHabit.isDeleted() || !tmplHabit.Schedule.isDue(dayOf(now), tmplHabit.CreatedAt) {
+			continue
+		}
+		h := tmplHabit
+		h.CreatedAt = now
+		for _, c := range dayItems {
+			if c.ID == tmplHabit.ID {
+				h.CreatedAt = c.CreatedAt
+				h.CompletedAt = c.CompletedAt
+				h.Value = c.Value
+				if h.isQuantity() {
+					h.setValue(h.Value, now)
+				}
+				break
+			}
+		}
+		resolved = append(resolved, h)
+	}
+	return resolved
+}
+
 type dailySummary struct {
 	NumCompl int     `json:"n"`
 	PctCompl float32 `json:"p"`
 }
 
+// newSummaryOfList summarizes a day's habits. Weekly goal habits don't count
+// towards a single day's progress since they're judged by the week.
 func newSummaryOfList(list []habit) dailySummary {
-	numCompl := 0
-	for _, h := range list {
+	numDue, numCompl := 0, 0
+	var credit float32
+	for i := range list {
+		h := &list[i]
+		if h.Schedule.isWeeklyGoal() {
+			continue
+		}
+		numDue++
+		credit += h.credit()
 		if h.isDone() {
 			numCompl++
 		}
 	}
+	// A day with nothing due (e.g. due to habit schedules) has no progress to show.
+	if numDue == 0 {
+		return dailySummary{}
+	}
 	return dailySummary{
 		NumCompl: numCompl,
-		PctCompl: float32(numCompl) / float32(len(list)),
+		PctCompl: credit / float32(numDue),
 	}
 }
 
+type weeklySummary struct {
+	NumGoals int `json:"g"`
+	NumMet   int `json:"m"`
+}
+
+// isMet reports whether every weekly goal for the week has been met.
+func (ws weeklySummary) isMet() bool {
+	return ws.NumGoals > 0 && ws.NumMet == ws.NumGoals
+}
+
 type App struct {
 	store     *store
+	updates   chan<- any
 	splashErr splashErr
-	home      homeScreen
-	habits    *habitScreen
+	// raise switches to the window that has the database open when this one
+	// can't open it.
+	raise widget.Clickable
+	// restore offers to restore a backup when the database is damaged.
+	restore *restoreSplash
+	// unlock asks for the passphrase when the database is encrypted.
+	unlock *unlockSplash
+	// pendingNotice is shown on the home screen once it's loaded.
+	pendingNotice string
+	home          homeScreen
+	habits        *habitScreen
+	stats         *statsScreen
 }
 
 func (a *App) handleKeyEvent(ke key.Event) {
@@ -73,6 +192,20 @@ func (a *App) handleKeyEvent(ke key.Event) {
 			a.habits.newItem.Focus()
 		case key.NameEscape:
 			a.habits = nil
+		case key.NameUpArrow:
+			if ke.State == key.Press && ke.Modifiers.Contain(key.ModAlt) {
+				a.habits.moveSelected(-1)
+			}
+		case key.NameDownArrow:
+			if ke.State == key.Press && ke.Modifiers.Contain(key.ModAlt) {
+				a.habits.moveSelected(1)
+			}
+		}
+		return
+	}
+	if a.stats != nil {
+		if ke.Name == key.NameEscape {
+			a.stats = nil
 		}
 		return
 	}
@@ -81,6 +214,16 @@ func (a *App) handleKeyEvent(ke key.Event) {
 func (a *App) layout(gtx C, th *material.Theme) D {
 	paint.Fill(gtx.Ops, th.Bg)
 	if a.store == nil {
+		if a.restore != nil {
+			return a.restore.layout(gtx, th)
+		}
+		if a.unlock != nil {
+			return a.unlock.layout(gtx, th)
+		}
+		var locked errStoreLocked
+		if errors.As(a.splashErr, &locked) {
+			return a.layLockedSplash(gtx, th, locked)
+		}
 		if a.splashErr != nil {
 			return layout.Center.Layout(gtx, func(gtx C) D {
 				return material.Body1(th, a.splashErr.Error()).Layout(gtx)
@@ -94,87 +237,306 @@ func (a *App) layout(gtx C, th *material.Theme) D {
 	if a.habits != nil {
 		return a.habits.layout(gtx, th)
 	}
+	if a.stats != nil {
+		return a.stats.layout(gtx, th)
+	}
 	return a.home.layout(gtx, th)
 }
 
+// layLockedSplash explains that another Todaily process has the database open
+// and offers to switch to its window.
+func (a *App) layLockedSplash(gtx C, th *material.Theme, locked errStoreLocked) D {
+	if a.raise.Clicked() {
+		go func() {
+			if err := raiseRunningWindow(locked.fpath); err != nil {
+				a.updates <- splashErr(fmt.Errorf("switching to the open window: %w", err))
+				return
+			}
+			a.updates <- closeWindow{}
+		}()
+	}
+	return layout.Center.Layout(gtx, func(gtx C) D {
+		gtx.Constraints.Max.X = 460
+		return layout.Flex{Axis: layout.Vertical, Alignment: layout.Middle}.Layout(gtx,
+			layout.Rigid(material.H6(th, "Todaily is already open").Layout),
+			layout.Rigid(layout.Spacer{Height: 12}.Layout),
+			layout.Rigid(func(gtx C) D {
+				msg := fmt.Sprintf("Another Todaily window (or command) is using %s, and only one can use it at a time.", locked.fpath)
+				lbl := material.Body1(th, msg)
+				lbl.Alignment = text.Middle
+				return lbl.Layout(gtx)
+			}),
+			layout.Rigid(layout.Spacer{Height: 20}.Layout),
+			layout.Rigid(material.Button(th, &a.raise, "Switch to the Open Window").Layout),
+		)
+	})
+}
+
 func (a *App) mergeHabitTemplateWithToday(u applyHabitsToToday) {
-	fmtDate := time.Now().Format("060102")
-	todaysHabits, err := a.store.getHabitsForDay(fmtDate)
+	fmtDate := todayKey()
+	resolved, err := a.store.applyTemplateToDay(fmtDate, u.habits)
 	if err != nil {
-		a.home.errors.add("reading today's habits", err)
+		a.home.errors.add("applying habits to today", err)
 		return
 	}
-	var resolved []habit
-	for _, tmplHabit := range u.habits {
-		var currentHabit *habit
-		for i, c := range todaysHabits {
-			if c.ID == tmplHabit.ID && !tmplHabit.isDeleted() {
-				currentHabit = &todaysHabits[i]
-				break
-			}
+	a.home.setSummary(fmtDate, newSummaryOfList(resolved))
+	if err := a.home.refreshWeek(fmtDate); err != nil {
+		a.home.errors.add("reading this week's progress", err)
+	}
+	a.home.refreshStreaks()
+	if a.home.record.fmtDate == fmtDate {
+		weekCompl, err := a.store.getWeekProgress(fmtDate)
+		if err != nil {
+			a.home.errors.add("reading this week's progress", err)
+			return
 		}
-		if currentHabit != nil {
-			resolved = append(resolved, *currentHabit)
-		} else {
-			resolved = append(resolved, tmplHabit)
+		record, err := newDailyRecord(fmtDate, resolved, weekCompl)
+		if err != nil {
+			a.home.errors.add("reading today's habits", err)
+			return
 		}
+		a.home.record = record
 	}
-	if err := a.store.putHabitsForDay(fmtDate, resolved); err != nil {
-		a.home.errors.add("saving today's new habits", err)
+}
+
+// raiseWindow is sent when another Todaily process asks to switch to this
+// window, since it has the database open.
+type raiseWindow struct{}
+
+// closeWindow is sent to close the window after switching to another one.
+type closeWindow struct{}
+
+// storeChanged is sent when the database was changed by something other than
+// the window itself, such as a forwarded command.
+type storeChanged struct{}
+
+// reloadHandOff holds everything shown in the window, freshly read from the
+// database after a `storeChanged`.
+type reloadHandOff struct {
+	err       error
+	gridRows  []gridRow
+	hasOlder  bool
+	streaks   map[int]streak
+	templates []habit
+	record    dailyRecordWidget
+}
+
+// reload reads everything shown in the window, with the grid starting from
+// the same day and the given day selected, and sends it back in a
+// `reloadHandOff`.
+func reload(s *store, gridFrom time.Time, fmtDate string, updates chan<- any) {
+	var u reloadHandOff
+	defer func() { updates <- u }()
+	if u.gridRows, u.hasOlder, u.err = loadDayGrid(s, gridFrom, dayOf(time.Now())); u.err != nil {
 		return
 	}
-	a.home.setSummary(fmtDate, newSummaryOfList(resolved))
-	if a.home.record.fmtDate == fmtDate {
-		checks := make([]widget.Bool, len(resolved))
-		for i := range resolved {
-			checks[i] = widget.Bool{Value: resolved[i].isDone()}
+	if u.streaks, u.err = s.getStreaks(); u.err != nil {
+		return
+	}
+	if u.templates, u.err = s.getHabits(); u.err != nil {
+		return
+	}
+	items, err := s.getHabitsForDay(fmtDate)
+	if err != nil {
+		u.err = err
+		return
+	}
+	weekCompl, err := s.getWeekProgress(fmtDate)
+	if err != nil {
+		u.err = err
+		return
+	}
+	u.record, u.err = newDailyRecord(fmtDate, items, weekCompl)
+}
+
+func (a *App) applyReload(u reloadHandOff) {
+	if u.err != nil {
+		a.home.errors.add("reloading after changes from elsewhere", u.err)
+		return
+	}
+	a.home.gridRows = u.gridRows
+	a.home.hasOlder = u.hasOlder
+	a.home.streaks = u.streaks
+	a.home.record = u.record
+	if a.habits != nil {
+		a.habits.habits = u.templates
+		a.habits.streaks = u.streaks
+	}
+}
+
+// dayCheckInterval is the longest the app goes without checking whether the
+// date has changed, so it notices even if the clock jumps, the computer sleeps
+// through the change, or the day start setting changes.
+const dayCheckInterval = time.Minute
+
+// dayChanged is sent when the date changes while the app is open.
+type dayChanged struct {
+	prev  string
+	today string
+}
+
+// watchForNewDay sends a `dayChanged` whenever the date moves on from the
+// given one.
+func watchForNewDay(fmtDate string, updates chan<- any) {
+	for {
+		now := time.Now()
+		wait := nextDayStart(now).Sub(now)
+		if wait > dayCheckInterval {
+			wait = dayCheckInterval
 		}
-		a.home.record.checks = checks
-		a.home.record.habits = resolved
+		time.Sleep(wait)
+		if today := todayKey(); today != fmtDate {
+			updates <- dayChanged{prev: fmtDate, today: today}
+			fmtDate = today
+		}
+	}
+}
+
+// newDayHandOff holds what changes in the window when the date changes, read
+// from the database after a `dayChanged`.
+type newDayHandOff struct {
+	err     error
+	prev    string
+	today   string
+	streaks map[int]streak
+	// record is the new day's record, which is only read if the old day was
+	// selected.
+	record *dailyRecordWidget
+}
+
+// rollOver starts the record for the new day and, if the old day was
+// selected, reads the new one to select instead. It sends back a
+// `newDayHandOff`.
+func rollOver(s *store, u dayChanged, selected string, updates chan<- any) {
+	h := newDayHandOff{prev: u.prev, today: u.today}
+	defer func() { updates <- h }()
+	items, err := s.getHabitsForDay(u.today)
+	if err != nil {
+		h.err = err
+		return
+	}
+	if h.streaks, h.err = s.getStreaks(); h.err != nil {
+		return
+	}
+	if selected != u.prev {
+		return
+	}
+	weekCompl, err := s.getWeekProgress(u.today)
+	if err != nil {
+		h.err = err
+		return
+	}
+	record, err := newDailyRecord(u.today, items, weekCompl)
+	if err != nil {
+		h.err = err
+		return
+	}
+	h.record = &record
+}
+
+func (a *App) applyNewDay(u newDayHandOff) {
+	if u.err != nil {
+		a.home.errors.add("starting the new day", u.err)
+		return
+	}
+	t, err := parseKey(u.today)
+	if err != nil {
+		a.home.errors.add("starting the new day", err)
+		return
+	}
+	a.home.gridRows = extendDayGrid(a.home.gridRows, t)
+	a.home.streaks = u.streaks
+	// Only move the selection if it hasn't been moved since.
+	if u.record != nil && a.home.record.fmtDate == u.prev {
+		a.home.record = *u.record
 	}
 }
 
 type splashErr error
 
 type splashHandOff struct {
-	store     *store
-	summaries map[string]dailySummary
-	record    dailyRecordWidget
+	store *store
+	// control is where commands are forwarded from the command line, or nil
+	// if it couldn't be set up.
+	control  net.Listener
+	gridRows []gridRow
+	hasOlder bool
+	streaks  map[int]streak
+	record   dailyRecordWidget
 }
 
-func initLoad(dbFile string, updates chan<- any) {
-	store, err := openStore(dbFile)
+// initLoad opens the database and loads everything the home screen needs.
+// The passphrase is only used if the database is encrypted, and may be nil
+// until it's been asked for.
+func initLoad(dbFile string, passphrase []byte, updates chan<- any) {
+	var ask func(string) ([]byte, error)
+	if passphrase != nil {
+		ask = func(string) ([]byte, error) { return passphrase, nil }
+	}
+	store, err := openStore(dbFile, ask)
+	var corrupt errStoreCorrupt
+	var encrypted errStoreEncrypted
+	if errors.As(err, &encrypted) {
+		updates <- splashEncrypted{err: encrypted}
+		return
+	}
+	if errors.As(err, &corrupt) {
+		backups, lerr := listBackups(corrupt.fpath)
+		if lerr != nil {
+			log.Printf("listing backups: %v", lerr)
+		}
+		updates <- splashCorrupt{err: corrupt, backups: backups}
+		return
+	}
 	if err != nil {
 		updates <- splashErr(err)
 		return
 	}
-	summaries, err := store.getSummaries()
+	today := dayOf(time.Now())
+	gridRows, hasOlder, err := loadDayGrid(store, gridStart(today), today)
 	if err != nil {
 		updates <- splashErr(err)
 		return
 	}
-	fmtDate := time.Now().Format("060102")
+	fmtDate := today.Format(keyFormat)
 	todaysHabits, err := store.getHabitsForDay(fmtDate)
 	if err != nil {
 		updates <- splashErr(err)
 		return
 	}
-	record, err := newDailyRecord(fmtDate, todaysHabits)
+	streaks, err := store.getStreaks()
 	if err != nil {
 		updates <- splashErr(err)
 		return
 	}
+	weekCompl, err := store.getWeekProgress(fmtDate)
+	if err != nil {
+		updates <- splashErr(err)
+		return
+	}
+	record, err := newDailyRecord(fmtDate, todaysHabits, weekCompl)
+	if err != nil {
+		updates <- splashErr(err)
+		return
+	}
+	control, err := listenControl(store)
+	if err != nil {
+		log.Printf("commands won't be able to reach this window: %v", err)
+	}
 	updates <- splashHandOff{
-		store:     store,
-		summaries: summaries,
-		record:    record,
+		store:    store,
+		control:  control,
+		gridRows: gridRows,
+		hasOlder: hasOlder,
+		streaks:  streaks,
+		record:   record,
 	}
 }
 
 func run(dbFile string, showFrameTimes bool) error {
 	updates := make(chan any)
 
-	go initLoad(dbFile, updates)
+	go initLoad(dbFile, nil, updates)
 
 	win := app.NewWindow(
 		app.Size(720, 720),
@@ -191,7 +553,7 @@ func run(dbFile string, showFrameTimes bool) error {
 		ContrastBg: color.NRGBA{40, 170, 196, 255},
 	}
 
-	var a App
+	a := App{updates: updates}
 	var ops op.Ops
 	for {
 		select {
@@ -199,29 +561,101 @@ func run(dbFile string, showFrameTimes bool) error {
 			switch u := u.(type) {
 			case splashErr:
 				a.splashErr = u
+				a.restore = nil
+				a.unlock = nil
+			case splashEncrypted:
+				if a.unlock == nil {
+					a.unlock = &unlockSplash{
+						updates:    updates,
+						passphrase: widget.Editor{SingleLine: true, Submit: true, Mask: '•'},
+					}
+				}
+				a.unlock.fpath = u.err.fpath
+				a.unlock.wrong = u.err.wrong
+				a.unlock.busy = false
+				a.unlock.passphrase.Focus()
+			case splashCorrupt:
+				a.restore = &restoreSplash{
+					updates: updates,
+					err:     u.err,
+					backups: u.backups,
+					buttons: make([]widget.Clickable, len(u.backups)),
+					list:    widget.List{List: layout.List{Axis: layout.Vertical}},
+				}
+			case restoredBackup:
+				a.restore = nil
+				a.pendingNotice = u.notice
+				go initLoad(u.dbPath, nil, updates)
+			case backupFailed:
+				a.home.errors.add("backing up the database", u.err)
 			case splashHandOff:
 				a.store = u.store
+				a.unlock = nil
+				if u.control != nil {
+					defer u.control.Close()
+					go serveControl(u.control, u.store, updates)
+				}
+				go keepBackedUp(u.store, updates)
+				go watchForNewDay(u.record.fmtDate, updates)
 				a.home = homeScreen{
 					store:      a.store,
 					updates:    updates,
-					gridRows:   newDayGrid(u.summaries),
+					gridRows:   u.gridRows,
+					gridList:   layout.List{Axis: layout.Vertical, ScrollToEnd: true},
+					hasOlder:   u.hasOlder,
 					habitList:  widget.List{List: layout.List{Axis: layout.Vertical}},
 					record:     u.record,
+					streaks:    u.streaks,
 					invalidate: win.Invalidate,
 				}
+				a.home.notice.set(a.pendingNotice)
+				a.pendingNotice = ""
 			case openHabitScreen:
 				a.habits = &habitScreen{
 					store:      a.store,
 					updates:    updates,
 					list:       widget.List{List: layout.List{Axis: layout.Vertical}},
 					newItem:    widget.Editor{SingleLine: true, Submit: true},
+					schedKind:  widget.Enum{Value: "daily"},
+					schedEvery: widget.Editor{SingleLine: true, Filter: "0123456789"},
+					schedTimes: widget.Editor{SingleLine: true, Filter: "0123456789"},
+					target:     widget.Editor{SingleLine: true, Filter: "0123456789."},
+					unit:       widget.Editor{SingleLine: true},
 					habits:     u.items,
+					streaks:    u.streaks,
 					invalidate: win.Invalidate,
 				}
+			case raiseWindow:
+				win.Perform(system.ActionRaise)
+			case closeWindow:
+				win.Perform(system.ActionClose)
+			case storeChanged:
+				go reload(a.store, a.home.gridRows[0].cells[0].day, a.home.record.fmtDate, updates)
+			case reloadHandOff:
+				a.applyReload(u)
+			case olderWeeks:
+				a.home.prependWeeks(u)
+			case dayChanged:
+				go rollOver(a.store, u, a.home.record.fmtDate, updates)
+			case newDayHandOff:
+				a.applyNewDay(u)
 			case applyHabitsToToday:
 				a.mergeHabitTemplateWithToday(u)
+			case habitAdded:
+				if a.habits != nil {
+					a.habits.habits = append(a.habits.habits, u.habit)
+					a.habits.saveHabits()
+				}
 			case closeHabitScreen:
 				a.habits = nil
+			case openStatsScreen:
+				a.stats = &statsScreen{
+					updates: updates,
+					stats:   u.stats,
+					list:    widget.List{List: layout.List{Axis: layout.Vertical}},
+				}
+			case closeStatsScreen:
+				a.stats = nil
 			}
 			win.Invalidate()
 		case e := <-win.Events():
@@ -237,7 +671,7 @@ func run(dbFile string, showFrameTimes bool) error {
 				}
 				// Gather key input on the entire window area.
 				areaStack := clip.Rect(image.Rectangle{Max: gtx.Constraints.Max}).Push(gtx.Ops)
-				key.InputOp{Tag: win, Keys: "/|" + key.NameEscape}.Add(gtx.Ops)
+				key.InputOp{Tag: win, Keys: "/|" + key.NameEscape + "|Alt-[" + key.NameUpArrow + "," + key.NameDownArrow + "]"}.Add(gtx.Ops)
 				a.layout(gtx, th)
 				areaStack.Pop()
 				e.Frame(gtx.Ops)
@@ -253,7 +687,11 @@ func run(dbFile string, showFrameTimes bool) error {
 
 func main() {
 	showFrameTimes := flag.Bool("print-frame-times", false, "Print out how long each frame takes.")
+	flag.Usage = printUsage
 	flag.Parse()
+	if _, ok := commands()[flag.Arg(0)]; ok {
+		os.Exit(runCommand(flag.Arg(0), flag.Args()[1:]))
+	}
 	dbFile := flag.Arg(0)
 
 	go func() {
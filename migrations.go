@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// migration brings the database up from one schema version to the next.
+type migration struct {
+	desc string
+	run  func(tx *bbolt.Tx) error
+}
+
+// migrations are run in order on any database whose schema version is behind.
+// A database's schema version is the number of migrations that have been run
+// on it, so a migration must never be removed or reordered once released.
+var migrations = []migration{
+	{
+		desc: "create the initial buckets",
+		run: func(tx *bbolt.Tx) error {
+			return createBuckets(tx, "meta", "dailyRecords", "dailySummaries")
+		},
+	},
+	{
+		desc: "create the weekly summaries bucket",
+		run: func(tx *bbolt.Tx) error {
+			return createBuckets(tx, "weeklySummaries")
+		},
+	},
+	{
+		desc: "give colliding habit IDs unique ones",
+		run:  migrateHabitIDs,
+	},
+	{
+		desc: "key days by four-digit years",
+		run:  migrateFullYearKeys,
+	},
+}
+
+// schemaVersion is the schema version that this build of Todaily reads and writes.
+var schemaVersion = len(migrations)
+
+// errSchemaTooNew means a database was last written by a newer version of
+// Todaily than this one, which won't know how to read it safely.
+type errSchemaTooNew struct {
+	version int
+}
+
+func (e errSchemaTooNew) Error() string {
+	return fmt.Sprintf("this database is at schema version %d, but this version of Todaily only "+
+		"understands up to version %d. Please update Todaily to open it.", e.version, schemaVersion)
+}
+
+// migrate runs any migrations the given database needs to be at the current
+// schema version. A copy of the database file is saved beside it before an
+// existing database is migrated.
+func migrate(db *bbolt.DB, fpath string) error {
+	var version int
+	var isNew bool
+	if err := db.View(func(tx *bbolt.Tx) error {
+		meta := bucketOf(tx, "meta")
+		if meta.Bucket == nil {
+			isNew = true
+			return nil
+		}
+		return getPlain(meta, []byte("schemaVersion"), &version)
+	}); err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	if version > schemaVersion {
+		return errSchemaTooNew{version}
+	}
+	if version == schemaVersion {
+		return nil
+	}
+	if !isNew {
+		bakPath := fmt.Sprintf("%s.v%d.bak", fpath, version)
+		if err := db.View(func(tx *bbolt.Tx) error {
+			return tx.CopyFile(bakPath, 0o600)
+		}); err != nil {
+			return fmt.Errorf("backing up database before migrating: %w", err)
+		}
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		for v := version; v < schemaVersion; v++ {
+			if err := migrations[v].run(tx); err != nil {
+				return fmt.Errorf("migrating to schema version %d (%s): %w", v+1, migrations[v].desc, err)
+			}
+		}
+		if err := putPlain(bucketOf(tx, "meta"), []byte("schemaVersion"), schemaVersion); err != nil {
+			return fmt.Errorf("setting schema version: %w", err)
+		}
+		return nil
+	})
+}
+
+func createBuckets(tx *bbolt.Tx, names ...string) error {
+	for _, name := range names {
+		if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+			return fmt.Errorf("creating bucket %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// migrateHabitIDs gives a new, unique ID to any template habits that share an
+// ID (which was once assigned based on the length of the list), updates the
+// daily records to match, and then moves the meta bucket's sequence past the
+// highest ID in use.
+func migrateHabitIDs(tx *bbolt.Tx) error {
+	meta := bucketOf(tx, "meta")
+	var templates []habit
+	if err := get(meta, []byte("habits"), &templates); err != nil {
+		return fmt.Errorf("reading habit template list: %w", err)
+	}
+	maxID := 0
+	for _, h := range templates {
+		if h.ID > maxID {
+			maxID = h.ID
+		}
+	}
+	// The templates that share an ID, by that ID and in the order they're
+	// listed in, which is also the order their entries are in a day's record.
+	sharing := make(map[int][]int)
+	for i := range templates {
+		sharing[templates[i].ID] = append(sharing[templates[i].ID], i)
+	}
+	reassigned := false
+	seen := make(map[int]bool)
+	for i := range templates {
+		h := &templates[i]
+		if !seen[h.ID] {
+			seen[h.ID] = true
+			continue
+		}
+		maxID++
+		h.ID = maxID
+		reassigned = true
+	}
+	sharers := make(map[int][]habit)
+	for old, indexes := range sharing {
+		if len(indexes) > 1 {
+			for _, i := range indexes {
+				sharers[old] = append(sharers[old], templates[i])
+			}
+		}
+	}
+	if reassigned {
+		if err := put(meta, []byte("habits"), templates); err != nil {
+			return fmt.Errorf("writing habit template list: %w", err)
+		}
+		dailys := bucketOf(tx, "dailyRecords")
+		changedDays := make(map[string][]habit)
+		if err := dailys.ForEach(func(k, v []byte) error {
+			var items []habit
+			if err := decode(dailys, k, v, &items); err != nil {
+				return fmt.Errorf("decoding habits for %q: %w", string(k), err)
+			}
+			if remapSharedIDs(items, sharers) {
+				changedDays[string(k)] = items
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		// Keys can't be written to while iterating over the bucket, so the
+		// changed days are rewritten afterwards. The weekly summaries are
+		// rebuilt by `migrateFullYearKeys`.
+		for fmtDate, items := range changedDays {
+			if err := put(dailys, []byte(fmtDate), items); err != nil {
+				return fmt.Errorf("writing habits for %q: %w", fmtDate, err)
+			}
+		}
+	}
+	if seq := meta.Sequence(); seq > uint64(maxID) {
+		return nil
+	}
+	return meta.SetSequence(uint64(maxID))
+}
+
+// remapSharedIDs gives the entries in a day's record that have one of the
+// shared IDs in `sharers` the new ID of the template they came from, and
+// reports whether any changed. An entry is matched to a template with the
+// same content, or else to one at the same position in the list. The rest
+// (such as entries from before a habit was both renamed and moved) are
+// matched by their order in the record, which follows the template list.
+func remapSharedIDs(items []habit, sharers map[int][]habit) bool {
+	changed := false
+	byID := make(map[int][]int)
+	for i := range items {
+		if _, ok := sharers[items[i].ID]; ok {
+			byID[items[i].ID] = append(byID[items[i].ID], i)
+		}
+	}
+	for old, entries := range byID {
+		shared := sharers[old]
+		taken := make([]bool, len(shared))
+		matched := make([]int, len(entries))
+		for n := range matched {
+			matched[n] = -1
+		}
+		for _, same := range []func(e, t *habit) bool{
+			func(e, t *habit) bool { return e.Content == t.Content },
+			func(e, t *habit) bool { return e.Pos == t.Pos },
+			func(e, t *habit) bool { return true },
+		} {
+			for n, i := range entries {
+				for j := 0; matched[n] < 0 && j < len(shared); j++ {
+					if !taken[j] && same(&items[i], &shared[j]) {
+						matched[n] = j
+						taken[j] = true
+					}
+				}
+			}
+		}
+		for n, i := range entries {
+			if j := matched[n]; j >= 0 && items[i].ID != shared[j].ID {
+				items[i].ID = shared[j].ID
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// migrateFullYearKeys rekeys the daily records from YYMMDD dates to YYYYMMDD
+// ones so that they sort in date order across centuries, and then rebuilds the
+// daily and weekly summaries under the new keys. Every date Todaily has
+// written is in the 2000s. Keys that aren't YYMMDD dates are left as they are.
+func migrateFullYearKeys(tx *bbolt.Tx) error {
+	dailys := bucketOf(tx, "dailyRecords")
+	type kv struct{ k, v []byte }
+	var moves []kv
+	if err := dailys.ForEach(func(k, v []byte) error {
+		if v != nil && isOldDateKey(k) {
+			moves = append(moves, kv{append([]byte(nil), k...), append([]byte(nil), v...)})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// As with the habit IDs, keys can't be changed while iterating. Values
+	// are read and put again rather than moved as they are, since encrypted
+	// ones are sealed along with their keys.
+	for _, m := range moves {
+		var items json.RawMessage
+		if err := decode(dailys, m.k, m.v, &items); err != nil {
+			return fmt.Errorf("decoding habits for %q: %w", m.k, err)
+		}
+		if err := dailys.Delete(m.k); err != nil {
+			return fmt.Errorf("removing habits for %q: %w", m.k, err)
+		}
+		if err := put(dailys, append([]byte("20"), m.k...), items); err != nil {
+			return fmt.Errorf("rekeying habits for %q: %w", m.k, err)
+		}
+	}
+	return rebuildSummaries(tx)
+}
+
+func isOldDateKey(k []byte) bool {
+	if len(k) != 6 {
+		return false
+	}
+	for _, c := range k {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
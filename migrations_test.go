@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestMigrateHabitIDs(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "db.todaily"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// "Stretch" and "Walk" were both given ID 2, and "Stretch" was once
+	// called "Stretching".
+	templates := []habit{
+		{ID: 1, Content: "Read", Pos: 0},
+		{ID: 2, Content: "Walk", Pos: 1},
+		{ID: 2, Content: "Stretch", Pos: 2},
+	}
+	records := map[string][]habit{
+		"240101": {{ID: 1, Content: "Read", Pos: 0}, {ID: 2, Content: "Walk", Pos: 1}, {ID: 2, Content: "Stretching", Pos: 2}},
+		"240102": {{ID: 1, Content: "Read", Pos: 0}, {ID: 2, Content: "Walk", Pos: 1}, {ID: 2, Content: "Stretch", Pos: 2}},
+		"240103": {{ID: 2, Content: "Stretching", Pos: 2}},
+		"240104": {{ID: 2, Content: "Stretch", Pos: 2}, {ID: 1, Content: "Read", Pos: 0}},
+		// Before "Walk" and "Stretch" were renamed and moved.
+		"240105": {{ID: 2, Content: "Walking", Pos: 5}, {ID: 2, Content: "Stretching", Pos: 6}},
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		if err := createBuckets(tx, "meta", "dailyRecords"); err != nil {
+			return err
+		}
+		if err := put(bucketOf(tx, "meta"), []byte("habits"), templates); err != nil {
+			return err
+		}
+		for k, items := range records {
+			if err := put(bucketOf(tx, "dailyRecords"), []byte(k), items); err != nil {
+				return err
+			}
+		}
+		return migrateHabitIDs(tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantIDs := map[string]int{"Read": 1, "Walk": 2, "Stretch": 3}
+	want := map[string][]int{
+		"240101": {1, 2, 3},
+		"240102": {1, 2, 3},
+		"240103": {3},
+		"240104": {3, 1},
+		"240105": {2, 3},
+	}
+	err = db.View(func(tx *bbolt.Tx) error {
+		meta := bucketOf(tx, "meta")
+		var got []habit
+		if err := get(meta, []byte("habits"), &got); err != nil {
+			return err
+		}
+		for _, h := range got {
+			if h.ID != wantIDs[h.Content] {
+				t.Errorf("template %q has ID %d, want %d", h.Content, h.ID, wantIDs[h.Content])
+			}
+		}
+		if seq := meta.Sequence(); seq != 3 {
+			t.Errorf("meta's sequence is %d, want 3", seq)
+		}
+		for k, ids := range want {
+			var items []habit
+			if err := get(bucketOf(tx, "dailyRecords"), []byte(k), &items); err != nil {
+				return err
+			}
+			if len(items) != len(ids) {
+				t.Errorf("%s has %d habits, want %d", k, len(items), len(ids))
+				continue
+			}
+			for i, id := range ids {
+				if items[i].ID != id {
+					t.Errorf("%s: %q has ID %d, want %d", k, items[i].Content, items[i].ID, id)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedule describes which days a habit is due on. The zero value is a
+// habit that is due every day.
+type schedule struct {
+	Kind     scheduleKind `json:"k,omitempty"`
+	Weekdays weekdaySet   `json:"wd,omitempty"`
+	Interval int          `json:"n,omitempty"`
+	PerWeek  int          `json:"pw,omitempty"`
+}
+
+type scheduleKind int
+
+const (
+	// scheduleDaily habits are due every day.
+	scheduleDaily scheduleKind = iota
+	// scheduleWeekdays habits are due on a specific set of weekdays.
+	scheduleWeekdays
+	// scheduleInterval habits are due every `Interval` days, counting from
+	// the day the habit was created.
+	scheduleInterval
+	// scheduleWeekly habits are offered every day until they have been done
+	// `PerWeek` times in the current week. They're judged by the week rather
+	// than by the day.
+	scheduleWeekly
+)
+
+// weekdaySet is a bit set of weekdays where bit `n` is `time.Weekday(n)`.
+type weekdaySet uint8
+
+const workWeek weekdaySet = 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday | 1<<time.Friday
+
+func (ws weekdaySet) has(d time.Weekday) bool {
+	return ws&(1<<d) != 0
+}
+
+func (ws weekdaySet) with(d time.Weekday) weekdaySet {
+	return ws | 1<<d
+}
+
+// isDue reports whether a habit with this schedule, which was created at
+// `created`, is due on the day starting at midnight `t`.
+func (s schedule) isDue(t, created time.Time) bool {
+	switch s.Kind {
+	case scheduleWeekdays:
+		return s.Weekdays.has(t.Weekday())
+	case scheduleInterval:
+		if s.Interval <= 1 {
+			return true
+		}
+		n := daysBetween(dayOf(created), t)
+		return n >= 0 && n%s.Interval == 0
+	}
+	return true
+}
+
+func (s schedule) String() string {
+	switch s.Kind {
+	case scheduleWeekdays:
+		if s.Weekdays == workWeek {
+			return "Weekdays"
+		}
+		var days []string
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if s.Weekdays.has(d) {
+				days = append(days, d.String()[:3])
+			}
+		}
+		return strings.Join(days, ", ")
+	case scheduleInterval:
+		if s.Interval > 1 {
+			return "Every " + strconv.Itoa(s.Interval) + " days"
+		}
+	case scheduleWeekly:
+		if s.PerWeek == 1 {
+			return "Once a week"
+		}
+		return strconv.Itoa(s.PerWeek) + " times a week"
+	}
+	return "Every day"
+}
+
+// isWeeklyGoal reports whether habits with this schedule are judged by the week.
+// validate returns an error if the schedule is one that can't be given to a
+// habit, the same as `todaily add` would refuse.
+func (s schedule) validate() error {
+	if _, ok := scheduleKindNames[s.Kind]; !ok {
+		return fmt.Errorf("unknown schedule kind %d", s.Kind)
+	}
+	switch {
+	case s.Interval < 0:
+		return fmt.Errorf("every %d days isn't a valid interval", s.Interval)
+	case s.PerWeek < 0 || s.PerWeek > 7:
+		return fmt.Errorf("%d times a week isn't a valid weekly goal", s.PerWeek)
+	case s.Kind == scheduleWeekly && s.PerWeek == 0:
+		return errors.New("a weekly goal has to be 1 to 7 times a week")
+	}
+	return nil
+}
+
+func (s schedule) isWeeklyGoal() bool {
+	return s.Kind == scheduleWeekly
+}
+
+// weekStart returns the Sunday that begins the week of `t`.
+func weekStart(t time.Time) time.Time {
+	return t.AddDate(0, 0, -int(t.Weekday()))
+}
+
+// daysBetween returns the number of calendar days from the day of `a` to the
+// day of `b`. It is negative if `b` falls on an earlier day than `a`.
+func daysBetween(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	// Use noon UTC for both days so daylight saving changes can't skew the result.
+	da := time.Date(ay, am, ad, 12, 0, 0, 0, time.UTC)
+	db := time.Date(by, bm, bd, 12, 0, 0, 0, time.UTC)
+	return int(db.Sub(da).Hours() / 24)
+}
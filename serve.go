@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The API served by `todaily serve` uses the same habit and day objects as
+// the JSON export format (see export.go) and dates written as YYYY-MM-DD:
+//
+//	GET    /habits              the habit template list
+//	POST   /habits              add a habit from {"content", "schedule", "target", "unit"}
+//	GET    /habits/{id}         one habit
+//	PUT    /habits/{id}         change any of a habit's content, schedule, target or unit
+//	DELETE /habits/{id}         delete a habit (it can still be restored in the app)
+//	GET    /days/{date}         a day's habits and summary
+//	PUT    /days/{date}         replace a day's habits from {"habits": [...]}
+//	GET    /summaries?from=&to= daily and weekly summaries, optionally limited to a range
+//	GET    /streaks             the current and longest streak of each habit
+//
+// Errors are given as {"error": "..."} along with a fitting status code.
+//
+// Since there's no authentication, requests are only answered when their Host
+// is localhost or a loopback address, so that a web page can't reach the API
+// by pointing its own domain at 127.0.0.1. Request bodies have to be sent as
+// application/json, which a web page can't do from another site without the
+// browser asking first.
+//
+// While the app has the database open, requests are passed along to it over
+// its control socket (see ipc.go) and answered with its own store, so the
+// window shows any changes right away. Otherwise the database is only opened
+// for the length of each request so that the server doesn't keep the app
+// from opening it. While some other process holds the database, requests fail
+// with 503 Service Unavailable. An encrypted database's passphrase is asked
+// for once and kept for later requests, and so is the key derived from it
+// (see `cryptHeader.unlock`).
+
+type apiServer struct {
+	dbFile string
+	// store is the app's own store when answering a forwarded request.
+	store *store
+	// mu keeps requests from opening the database at the same time, since
+	// the file lock would make them wait on each other anyway.
+	mu sync.Mutex
+	// passphrase is the encrypted database's passphrase once it's known.
+	passphrase []byte
+}
+
+// withStore opens the database for the duration of `fn`.
+func (as *apiServer) withStore(fn func(s *store) error) error {
+	if as.store != nil {
+		return fn(as.store)
+	}
+	s, err := openStore(as.dbFile, func(fpath string) ([]byte, error) {
+		if as.passphrase == nil {
+			p, err := terminalPassphrase(fpath)
+			if err != nil {
+				return nil, err
+			}
+			as.passphrase = p
+		}
+		return as.passphrase, nil
+	})
+	var encrypted errStoreEncrypted
+	if errors.As(err, &encrypted) && encrypted.wrong {
+		as.passphrase = nil
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// apiError is an error with the HTTP status code it should be reported with.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e apiError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, a ...any) error {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, a...)}
+}
+
+func notFound(format string, a ...any) error {
+	return apiError{http.StatusNotFound, fmt.Sprintf(format, a...)}
+}
+
+// apiHandler handles a request with the database open, returning the value
+// to respond with as JSON.
+type apiHandler func(r *http.Request, s *store) (status int, v any, err error)
+
+func (as *apiServer) handle(methods map[string]apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(r.Host) {
+			writeAPIError(w, apiError{http.StatusForbidden, "requests have to be made to localhost or a loopback address"})
+			return
+		}
+		h, ok := methods[r.Method]
+		if !ok {
+			allowed := make([]string, 0, len(methods))
+			for m := range methods {
+				allowed = append(allowed, m)
+			}
+			sort.Strings(allowed)
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, apiError{http.StatusMethodNotAllowed, r.Method + " is not allowed here"})
+			return
+		}
+		if as.store == nil {
+			forwarded, err := as.forward(w, r)
+			if err != nil {
+				writeAPIError(w, err)
+				return
+			}
+			if forwarded {
+				return
+			}
+		}
+		as.mu.Lock()
+		defer as.mu.Unlock()
+		var status int
+		var v any
+		err := as.withStore(func(s *store) (err error) {
+			status, v, err = h(r, s)
+			return err
+		})
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		if v == nil {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			log.Printf("writing response to %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
+
+// forward passes the request along to the app if it has the database open,
+// writing out the app's response. It reports whether the request was passed
+// along.
+func (as *apiServer) forward(w http.ResponseWriter, r *http.Request) (bool, error) {
+	dbPath, err := resolveDBPath(as.dbFile)
+	if err != nil {
+		return false, err
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false, badRequest("reading request body: %v", err)
+	}
+	// The body has to be there to read again if the request isn't forwarded.
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	resp, forwarded, err := forwardAPIRequest(dbPath, &controlAPIRequest{
+		Method:      r.Method,
+		URL:         r.URL.RequestURI(),
+		Host:        r.Host,
+		ContentType: r.Header.Get("Content-Type"),
+		Body:        body,
+	})
+	if !forwarded || err != nil {
+		return forwarded, err
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.Status)
+	if _, err := w.Write(resp.Body); err != nil {
+		log.Printf("writing response to %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	return true, nil
+}
+
+// serveForwardedAPI answers an API request passed along by `todaily serve`
+// with the app's store.
+func serveForwardedAPI(s *store, req *controlAPIRequest) *controlAPIResponse {
+	r, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return &controlAPIResponse{Status: http.StatusBadRequest}
+	}
+	r.Host = req.Host
+	if req.ContentType != "" {
+		r.Header.Set("Content-Type", req.ContentType)
+	}
+	w := &apiRecorder{header: make(http.Header), status: http.StatusOK}
+	(&apiServer{store: s}).routes().ServeHTTP(w, r)
+	return &controlAPIResponse{Status: w.status, Header: w.header, Body: w.body.Bytes()}
+}
+
+// apiRecorder keeps the response to a forwarded API request so that it can be
+// sent back.
+type apiRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func (w *apiRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *apiRecorder) WriteHeader(status int) {
+	if !w.wrote {
+		w.status, w.wrote = status, true
+	}
+}
+
+func (w *apiRecorder) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.body.Write(b)
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var ae apiError
+	var locked errStoreLocked
+	switch {
+	case errors.As(err, &ae):
+		status = ae.status
+	case errors.As(err, &locked):
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "5")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// isLoopbackHost reports whether a host, with or without a port, is
+// localhost or a loopback address.
+func isLoopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func decodeBody(r *http.Request, v any) error {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		return apiError{http.StatusUnsupportedMediaType, "the request body has to be sent as application/json"}
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("decoding request body: %v", err)
+	}
+	return nil
+}
+
+// parseAPIDate returns the database key for a YYYY-MM-DD date.
+func parseAPIDate(date string) (string, error) {
+	t, err := parseDate(date)
+	if err != nil {
+		return "", badRequest("invalid date %q", date)
+	}
+	return t.Format(keyFormat), nil
+}
+
+func (as *apiServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/habits", as.handle(map[string]apiHandler{
+		http.MethodGet:  listHabits,
+		http.MethodPost: createHabit,
+	}))
+	mux.HandleFunc("/habits/", as.handle(map[string]apiHandler{
+		http.MethodGet:    getHabit,
+		http.MethodPut:    updateHabit,
+		http.MethodDelete: deleteHabit,
+	}))
+	mux.HandleFunc("/days/", as.handle(map[string]apiHandler{
+		http.MethodGet: getDay,
+		http.MethodPut: putDay,
+	}))
+	mux.HandleFunc("/summaries", as.handle(map[string]apiHandler{
+		http.MethodGet: listSummaries,
+	}))
+	mux.HandleFunc("/streaks", as.handle(map[string]apiHandler{
+		http.MethodGet: listStreaks,
+	}))
+	return mux
+}
+
+func listHabits(r *http.Request, s *store) (int, any, error) {
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	list := make([]exportHabit, len(templates))
+	for i := range templates {
+		list[i] = newExportHabit(&templates[i])
+	}
+	return http.StatusOK, list, nil
+}
+
+// findTemplate returns the index of the habit with the ID at the end of the
+// request's path.
+func findTemplate(r *http.Request, templates []habit) (int, error) {
+	idText := strings.TrimPrefix(r.URL.Path, "/habits/")
+	id, err := strconv.Atoi(idText)
+	if err != nil {
+		return 0, badRequest("invalid habit ID %q", idText)
+	}
+	for i := range templates {
+		if templates[i].ID == id {
+			return i, nil
+		}
+	}
+	return 0, notFound("no habit with ID %d", id)
+}
+
+func getHabit(r *http.Request, s *store) (int, any, error) {
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	i, err := findTemplate(r, templates)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newExportHabit(&templates[i]), nil
+}
+
+func createHabit(r *http.Request, s *store) (int, any, error) {
+	eh := exportHabit{Schedule: exportSchedule{Kind: "daily"}}
+	if err := decodeBody(r, &eh); err != nil {
+		return 0, nil, err
+	}
+	if strings.TrimSpace(eh.Content) == "" {
+		return 0, nil, badRequest("a habit needs content")
+	}
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := s.newHabitID()
+	if err != nil {
+		return 0, nil, err
+	}
+	eh.ID = id
+	h, err := eh.toHabit()
+	if err != nil {
+		return 0, nil, badRequest("%v", err)
+	}
+	h.CreatedAt = time.Now()
+	h.CompletedAt, h.DeletedAt, h.Value = time.Time{}, time.Time{}, 0
+	h.Pos = len(templates)
+	if err := saveTemplates(s, append(templates, h)); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, newExportHabit(&h), nil
+}
+
+func updateHabit(r *http.Request, s *store) (int, any, error) {
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	i, err := findTemplate(r, templates)
+	if err != nil {
+		return 0, nil, err
+	}
+	old := templates[i]
+	// Start from the habit as it is so that only the given fields change.
+	eh := newExportHabit(&old)
+	if err := decodeBody(r, &eh); err != nil {
+		return 0, nil, err
+	}
+	h, err := eh.toHabit()
+	if err != nil {
+		return 0, nil, badRequest("%v", err)
+	}
+	h.ID, h.Pos, h.CreatedAt, h.DeletedAt = old.ID, old.Pos, old.CreatedAt, old.DeletedAt
+	h.CompletedAt, h.Value = time.Time{}, 0
+	templates[i] = h
+	if err := saveTemplates(s, templates); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newExportHabit(&h), nil
+}
+
+func deleteHabit(r *http.Request, s *store) (int, any, error) {
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	i, err := findTemplate(r, templates)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !templates[i].isDeleted() {
+		templates[i].DeletedAt = time.Now()
+		if err := saveTemplates(s, templates); err != nil {
+			return 0, nil, err
+		}
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// saveTemplates saves the habit template list and applies it to today's
+// record, as closing the habit screen does.
+func saveTemplates(s *store, templates []habit) error {
+	if err := s.putHabits(templates); err != nil {
+		return fmt.Errorf("saving habits: %w", err)
+	}
+	if _, err := s.applyTemplateToDay(todayKey(), templates); err != nil {
+		return fmt.Errorf("applying habits to today: %w", err)
+	}
+	return nil
+}
+
+func getDay(r *http.Request, s *store) (int, any, error) {
+	fmtDate, err := parseAPIDate(strings.TrimPrefix(r.URL.Path, "/days/"))
+	if err != nil {
+		return 0, nil, err
+	}
+	if fmtDate > todayKey() {
+		return 0, nil, badRequest("%s is in the future", r.URL.Path[len("/days/"):])
+	}
+	items, err := s.viewHabitsForDay(fmtDate)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newExportDay(fmtDate, items), nil
+}
+
+func putDay(r *http.Request, s *store) (int, any, error) {
+	date := strings.TrimPrefix(r.URL.Path, "/days/")
+	fmtDate, err := parseAPIDate(date)
+	if err != nil {
+		return 0, nil, err
+	}
+	if fmtDate > todayKey() {
+		return 0, nil, badRequest("%s is in the future", date)
+	}
+	var ed exportDay
+	if err := decodeBody(r, &ed); err != nil {
+		return 0, nil, err
+	}
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	known := make(map[int]bool, len(templates))
+	for i := range templates {
+		known[templates[i].ID] = true
+	}
+	items := make([]habit, len(ed.Habits))
+	seen := make(map[int]bool, len(ed.Habits))
+	for i := range ed.Habits {
+		h, err := ed.Habits[i].toHabit()
+		if err != nil {
+			return 0, nil, badRequest("%v", err)
+		}
+		if !known[h.ID] {
+			return 0, nil, badRequest("no habit with ID %d", h.ID)
+		}
+		if seen[h.ID] {
+			return 0, nil, badRequest("habit %d is listed more than once", h.ID)
+		}
+		seen[h.ID] = true
+		items[i] = h
+	}
+	if err := s.putHabitsForDay(fmtDate, items); err != nil {
+		return 0, nil, fmt.Errorf("saving habits for %s: %w", date, err)
+	}
+	return http.StatusOK, newExportDay(fmtDate, items), nil
+}
+
+func newExportDay(fmtDate string, items []habit) exportDay {
+	t, _ := parseKey(fmtDate)
+	summary := newSummaryOfList(items)
+	ed := exportDay{
+		Date:    t.Format("2006-01-02"),
+		Habits:  make([]exportHabit, len(items)),
+		Summary: exportSummary{Completed: summary.NumCompl, Percent: summary.PctCompl},
+	}
+	for i := range items {
+		ed.Habits[i] = newExportHabit(&items[i])
+	}
+	return ed
+}
+
+type apiSummaries struct {
+	Days  []apiDaySummary  `json:"days"`
+	Weeks []apiWeekSummary `json:"weeks"`
+}
+
+type apiDaySummary struct {
+	Date string `json:"date"`
+	exportSummary
+}
+
+type apiWeekSummary struct {
+	// Start is the Sunday that begins the week.
+	Start string `json:"start"`
+	Goals int    `json:"goals"`
+	Met   int    `json:"met"`
+}
+
+func listSummaries(r *http.Request, s *store) (int, any, error) {
+	var from, to string
+	for _, d := range []struct {
+		param string
+		dst   *string
+	}{{"from", &from}, {"to", &to}} {
+		if v := r.URL.Query().Get(d.param); v != "" {
+			fmtDate, err := parseAPIDate(v)
+			if err != nil {
+				return 0, nil, err
+			}
+			*d.dst = fmtDate
+		}
+	}
+	inRange := func(k string) bool {
+		return (from == "" || k >= from) && (to == "" || k <= to)
+	}
+	summaries, err := s.getSummaries(from, to)
+	if err != nil {
+		return 0, nil, err
+	}
+	// Weeks are keyed by their Sundays, so the week that overlaps the start
+	// of the range starts before it.
+	weeksFrom := from
+	if t, err := parseKey(from); err == nil {
+		weeksFrom = weekStart(t).Format(keyFormat)
+	}
+	weeklies, err := s.getWeeklySummaries(weeksFrom, to)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp := apiSummaries{Days: []apiDaySummary{}, Weeks: []apiWeekSummary{}}
+	for k, sum := range summaries {
+		t, _ := parseKey(k)
+		resp.Days = append(resp.Days, apiDaySummary{
+			Date:          t.Format("2006-01-02"),
+			exportSummary: exportSummary{Completed: sum.NumCompl, Percent: sum.PctCompl},
+		})
+	}
+	for k, sum := range weeklies {
+		// Include any week that overlaps the range.
+		t, _ := parseKey(k)
+		if !inRange(k) && !inRange(t.AddDate(0, 0, 6).Format(keyFormat)) {
+			continue
+		}
+		resp.Weeks = append(resp.Weeks, apiWeekSummary{
+			Start: t.Format("2006-01-02"),
+			Goals: sum.NumGoals,
+			Met:   sum.NumMet,
+		})
+	}
+	sort.Slice(resp.Days, func(i, j int) bool { return resp.Days[i].Date < resp.Days[j].Date })
+	sort.Slice(resp.Weeks, func(i, j int) bool { return resp.Weeks[i].Start < resp.Weeks[j].Start })
+	return http.StatusOK, resp, nil
+}
+
+type apiStreak struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+	streak
+}
+
+func listStreaks(r *http.Request, s *store) (int, any, error) {
+	templates, err := s.getHabits()
+	if err != nil {
+		return 0, nil, err
+	}
+	streaks, err := s.getStreaks()
+	if err != nil {
+		return 0, nil, err
+	}
+	list := []apiStreak{}
+	for i := range templates {
+		h := &templates[i]
+		if h.isDeleted() {
+			continue
+		}
+		list = append(list, apiStreak{ID: h.ID, Content: h.Content, streak: streaks[h.ID]})
+	}
+	return http.StatusOK, list, nil
+}
+
+func runServe(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "serve")
+	addr := fs.String("addr", "localhost:7270", "The address to listen on.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if _, _, err := net.SplitHostPort(*addr); err != nil {
+		return fmt.Errorf("invalid address %q: %w", *addr, err)
+	}
+	if !isLoopbackHost(*addr) {
+		return fmt.Errorf("refusing to serve on %q since the API has no authentication, use a loopback address", *addr)
+	}
+	// Make sure the database can be opened (and is migrated) before serving,
+	// unless the app has it open or it's only busy for now.
+	as := &apiServer{dbFile: *dbFile}
+	dbPath, err := resolveDBPath(*dbFile)
+	if err != nil {
+		return err
+	}
+	if conn, err := dialControl(dbPath); err == nil {
+		conn.Close()
+		log.Printf("%s is open in Todaily, requests will be passed along to it", dbPath)
+	} else {
+		err := as.withStore(func(s *store) error { return nil })
+		var locked errStoreLocked
+		if errors.As(err, &locked) {
+			log.Printf("%v, requests will fail until it's closed", err)
+		} else if err != nil {
+			return err
+		}
+	}
+	log.Printf("serving the Todaily API on http://%s", *addr)
+	return http.ListenAndServe(*addr, as.routes())
+}
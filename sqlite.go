@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sqliteMagic begins every SQLite database file.
+var sqliteMagic = []byte("SQLite format 3\x00")
+
+// sqliteFile reads the rows out of the tables of an SQLite database file. It
+// understands just enough of the file format (https://sqlite.org/fileformat.html)
+// to get at the data in another app's database backup and can't run queries.
+type sqliteFile struct {
+	data     []byte
+	pageSize int
+	// usable is the number of bytes of each page that aren't reserved for
+	// extensions.
+	usable int
+}
+
+// sqliteRow is a row of a table with the values of its columns by name.
+// Values are nil, int64, float64, string or []byte.
+type sqliteRow map[string]any
+
+func (r sqliteRow) int(col string) int64 {
+	switch v := r[col].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+func (r sqliteRow) float(col string) float64 {
+	switch v := r[col].(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func (r sqliteRow) string(col string) string {
+	switch v := r[col].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+var errSQLiteCorrupt = errors.New("the database file is damaged")
+
+func openSQLite(data []byte) (*sqliteFile, error) {
+	if len(data) < 100 || string(data[:len(sqliteMagic)]) != string(sqliteMagic) {
+		return nil, errors.New("not an SQLite database")
+	}
+	f := &sqliteFile{data: data, pageSize: int(binary.BigEndian.Uint16(data[16:]))}
+	if f.pageSize == 1 {
+		f.pageSize = 65536
+	}
+	if f.pageSize < 512 || f.pageSize&(f.pageSize-1) != 0 {
+		return nil, errSQLiteCorrupt
+	}
+	f.usable = f.pageSize - int(data[20])
+	if enc := binary.BigEndian.Uint32(data[56:]); enc > 1 {
+		return nil, errors.New("only UTF-8 SQLite databases are supported")
+	}
+	return f, nil
+}
+
+// readTable returns every row of the table with the given name in the order
+// of their row IDs.
+func (f *sqliteFile) readTable(name string) ([]sqliteRow, error) {
+	var root int64
+	var cols []string
+	var rowidCol string
+	// Page 1 is the root of the schema table, whose columns are type, name,
+	// tbl_name, rootpage and sql.
+	err := f.walkTable(1, func(_ int64, vals []any) error {
+		if len(vals) < 5 || vals[0] != "table" || !strings.EqualFold(fmt.Sprint(vals[1]), name) {
+			return nil
+		}
+		root, _ = vals[3].(int64)
+		sql, _ := vals[4].(string)
+		cols, rowidCol = parseSQLiteColumns(sql)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading the schema: %w", err)
+	}
+	if root == 0 {
+		return nil, fmt.Errorf("no %s table", name)
+	}
+	var rows []sqliteRow
+	err = f.walkTable(int(root), func(rowid int64, vals []any) error {
+		row := make(sqliteRow, len(cols))
+		for i, col := range cols {
+			// Columns added after a row was written are missing from it.
+			if i < len(vals) {
+				row[col] = vals[i]
+			}
+		}
+		if rowidCol != "" {
+			row[rowidCol] = rowid
+		}
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading the %s table: %w", name, err)
+	}
+	return rows, nil
+}
+
+// parseSQLiteColumns returns the column names from a CREATE TABLE statement
+// along with the one that's an alias for the row ID, if any.
+func parseSQLiteColumns(sql string) (cols []string, rowidCol string) {
+	start, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if start < 0 || end < start {
+		return nil, ""
+	}
+	var defs []string
+	depth, from := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[from:i])
+				from = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[from:end])
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		col := strings.Trim(fields[0], "\"`[]")
+		cols = append(cols, col)
+		// An INTEGER PRIMARY KEY column isn't stored in the row since it's
+		// the row ID.
+		if len(fields) >= 4 && strings.EqualFold(fields[1], "integer") &&
+			strings.EqualFold(fields[2], "primary") && strings.EqualFold(fields[3], "key") {
+			rowidCol = col
+		}
+	}
+	return cols, rowidCol
+}
+
+// page returns the contents of the page with the given number, counting from 1.
+func (f *sqliteFile) page(n int) ([]byte, error) {
+	off := (n - 1) * f.pageSize
+	if n < 1 || off+f.pageSize > len(f.data) {
+		return nil, errSQLiteCorrupt
+	}
+	return f.data[off : off+f.pageSize], nil
+}
+
+// walkTable calls `fn` with the row ID and values of each row in the table
+// b-tree rooted at page `n`.
+func (f *sqliteFile) walkTable(n int, fn func(rowid int64, vals []any) error) error {
+	return f.walkPage(n, make(map[int]bool), fn)
+}
+
+// walkPage is `walkTable` for the subtree at page `n`, where `seen` holds the
+// pages already walked.
+func (f *sqliteFile) walkPage(n int, seen map[int]bool, fn func(rowid int64, vals []any) error) error {
+	// Each page of a b-tree has just one parent, so a page that comes up
+	// again means the tree loops back on itself.
+	if seen[n] {
+		return errSQLiteCorrupt
+	}
+	seen[n] = true
+	pg, err := f.page(n)
+	if err != nil {
+		return err
+	}
+	hdr := 0
+	if n == 1 {
+		hdr = 100
+	}
+	if hdr+12 > len(pg) {
+		return errSQLiteCorrupt
+	}
+	kind := pg[hdr]
+	numCells := int(binary.BigEndian.Uint16(pg[hdr+3:]))
+	ptrs := hdr + 8
+	if kind == 0x05 {
+		ptrs = hdr + 12
+	}
+	if ptrs+2*numCells > len(pg) {
+		return errSQLiteCorrupt
+	}
+	for i := 0; i < numCells; i++ {
+		cell := int(binary.BigEndian.Uint16(pg[ptrs+2*i:]))
+		if cell >= f.usable {
+			return errSQLiteCorrupt
+		}
+		switch kind {
+		case 0x05: // interior page
+			if cell+4 > len(pg) {
+				return errSQLiteCorrupt
+			}
+			child := int(binary.BigEndian.Uint32(pg[cell:]))
+			if err := f.walkPage(child, seen, fn); err != nil {
+				return err
+			}
+		case 0x0d: // leaf page
+			rowid, payload, err := f.leafCell(pg, cell)
+			if err != nil {
+				return err
+			}
+			vals, err := decodeSQLiteRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(rowid, vals); err != nil {
+				return err
+			}
+		default:
+			return errSQLiteCorrupt
+		}
+	}
+	if kind == 0x05 {
+		return f.walkPage(int(binary.BigEndian.Uint32(pg[hdr+8:])), seen, fn)
+	}
+	return nil
+}
+
+// leafCell returns the row ID and record of the table leaf cell at `off`,
+// following its overflow pages if it has any.
+func (f *sqliteFile) leafCell(pg []byte, off int) (int64, []byte, error) {
+	size, n := sqliteVarint(pg[off:])
+	if n == 0 {
+		return 0, nil, errSQLiteCorrupt
+	}
+	off += n
+	rowid, n := sqliteVarint(pg[off:])
+	if n == 0 {
+		return 0, nil, errSQLiteCorrupt
+	}
+	off += n
+	if size < 0 || size > int64(len(f.data)) {
+		return 0, nil, errSQLiteCorrupt
+	}
+	total := int(size)
+	local := total
+	if maxLocal := f.usable - 35; total > maxLocal {
+		minLocal := (f.usable-12)*32/255 - 23
+		local = minLocal + (total-minLocal)%(f.usable-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if off+local > len(pg) {
+		return 0, nil, errSQLiteCorrupt
+	}
+	payload := append([]byte(nil), pg[off:off+local]...)
+	if local == total {
+		return rowid, payload, nil
+	}
+	if off+local+4 > len(pg) {
+		return 0, nil, errSQLiteCorrupt
+	}
+	next := int(binary.BigEndian.Uint32(pg[off+local:]))
+	for len(payload) < total {
+		ov, err := f.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		chunk := ov[4:f.usable]
+		if rest := total - len(payload); len(chunk) > rest {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+		next = int(binary.BigEndian.Uint32(ov))
+	}
+	return rowid, payload, nil
+}
+
+// decodeSQLiteRecord returns the values in a record.
+func decodeSQLiteRecord(rec []byte) ([]any, error) {
+	hdrSize, n := sqliteVarint(rec)
+	if n == 0 || hdrSize < int64(n) || hdrSize > int64(len(rec)) {
+		return nil, errSQLiteCorrupt
+	}
+	hdr, body := rec[n:hdrSize], rec[hdrSize:]
+	var vals []any
+	for len(hdr) > 0 {
+		typ, n := sqliteVarint(hdr)
+		if n == 0 {
+			return nil, errSQLiteCorrupt
+		}
+		hdr = hdr[n:]
+		var size int
+		switch {
+		case typ >= 1 && typ <= 4:
+			size = int(typ)
+		case typ == 5:
+			size = 6
+		case typ == 6 || typ == 7:
+			size = 8
+		case typ >= 12:
+			size = int((typ - 12) / 2)
+		}
+		if size > len(body) {
+			return nil, errSQLiteCorrupt
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case typ == 0:
+			vals = append(vals, nil)
+		case typ >= 1 && typ <= 6:
+			// Integers are big-endian two's complement of varying sizes.
+			i := int64(int8(v[0]))
+			for _, b := range v[1:] {
+				i = i<<8 | int64(b)
+			}
+			vals = append(vals, i)
+		case typ == 7:
+			vals = append(vals, math.Float64frombits(binary.BigEndian.Uint64(v)))
+		case typ == 8:
+			vals = append(vals, int64(0))
+		case typ == 9:
+			vals = append(vals, int64(1))
+		case typ >= 12 && typ%2 == 0:
+			vals = append(vals, append([]byte(nil), v...))
+		case typ >= 13:
+			vals = append(vals, string(v))
+		default:
+			return nil, errSQLiteCorrupt
+		}
+	}
+	return vals, nil
+}
+
+// sqliteVarint decodes one of SQLite's big-endian variable length integers
+// and returns it along with the number of bytes read, which is 0 if `b` is
+// too short.
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return int64(v<<8 | uint64(b[i])), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
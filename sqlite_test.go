@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testdata/loop-backup.db was made with sqlite3 using the tables of a Loop
+// Habit Tracker backup and 1024-byte pages. The "Read" habit's description
+// spills onto overflow pages and the Repetitions table is deep enough to have
+// an interior page.
+func readLoopFixture(t *testing.T) []byte {
+	data, err := os.ReadFile("testdata/loop-backup.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSQLiteOverflow(t *testing.T) {
+	f, err := openSQLite(readLoopFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := f.readTable("Habits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d habits, want 3", len(rows))
+	}
+	desc := rows[0].string("description")
+	if len(desc) <= f.usable || !strings.HasPrefix(desc, "Read at least one chapter before bed, 0. ") ||
+		!strings.HasSuffix(desc, ", 79. ") {
+		t.Errorf("description of %d bytes didn't come through whole", len(desc))
+	}
+	// The columns after the one that overflowed are still in place.
+	if name, uuid := rows[0].string("name"), rows[0].string("uuid"); name != "Read" || uuid != "a1" {
+		t.Errorf("got name %q and uuid %q, want \"Read\" and \"a1\"", name, uuid)
+	}
+	if id := rows[2].int("id"); id != 3 {
+		t.Errorf("third habit's id is %d, want 3", id)
+	}
+
+	reps, err := f.readTable("Repetitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reps) != 240 {
+		t.Fatalf("got %d repetitions, want 240", len(reps))
+	}
+	for i, r := range reps {
+		if r.int("id") != int64(i+1) {
+			t.Fatalf("repetition %d has id %d", i, r.int("id"))
+		}
+	}
+}
+
+func TestReadLoopBackup(t *testing.T) {
+	habits, err := readLoopBackup(readLoopFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, h := range habits {
+		names = append(names, h.name)
+	}
+	if got := strings.Join(names, ","); got != "Read,Water,Run" {
+		t.Fatalf("got habits %s, want Read,Water,Run in order of position", got)
+	}
+	read, water, run := habits[0], habits[1], habits[2]
+	// 2022-01-08 was marked as a miss and 2022-01-09 as done.
+	if e, ok := read.entries["20220108"]; !ok || e.done {
+		t.Errorf("Read on 2022-01-08 is %+v, %v, want a miss", e, ok)
+	}
+	if e := read.entries["20220109"]; !e.done {
+		t.Error("Read on 2022-01-09 isn't done")
+	}
+	if want := (schedule{Kind: scheduleWeekly, PerWeek: 3}); run.schedule != want {
+		t.Errorf("Run's schedule is %+v, want %+v", run.schedule, want)
+	}
+	if !water.archived || water.target != 8 || water.unit != "glasses" {
+		t.Errorf("Water is %+v, want archived with a target of 8 glasses", water)
+	}
+	if e := water.entries["20220110"]; e.value != 2 {
+		t.Errorf("Water on 2022-01-10 has a value of %v, want 2", e.value)
+	}
+	// Water was archived after its last entry, on 2022-03-08.
+	if _, ok := water.entries["20220309"]; ok {
+		t.Error("Water has an entry after it was archived")
+	}
+}
+
+func TestReadLoopBackupTruncated(t *testing.T) {
+	data := readLoopFixture(t)
+	for _, n := range []int{0, 16, 99, 100, 1024, 1500, len(data) / 2, len(data) - 1024, len(data) - 1} {
+		if _, err := readLoopBackup(data[:n]); err == nil {
+			t.Errorf("reading the first %d bytes succeeded", n)
+		}
+	}
+}
+
+func TestReadLoopBackupCorrupt(t *testing.T) {
+	data := readLoopFixture(t)
+	const pageSize = 1024
+	corrupt := map[string]func(b []byte){
+		"page size": func(b []byte) { binary.BigEndian.PutUint16(b[16:], 1000) },
+		"encoding":  func(b []byte) { binary.BigEndian.PutUint32(b[56:], 2) },
+		// Page 3 is the root of the Habits table.
+		"page type":    func(b []byte) { b[2*pageSize] = 0x07 },
+		"cell pointer": func(b []byte) { binary.BigEndian.PutUint16(b[2*pageSize+8:], 0xffff) },
+		// Page 5 is the interior root of the Repetitions table, and its
+		// rightmost child is made to point back at it.
+		"cycle": func(b []byte) { binary.BigEndian.PutUint32(b[4*pageSize+8:], 5) },
+		// Page 6 is the first of the description's overflow pages.
+		"overflow chain": func(b []byte) { binary.BigEndian.PutUint32(b[5*pageSize:], 1<<20) },
+	}
+	for name, fn := range corrupt {
+		b := append([]byte(nil), data...)
+		fn(b)
+		if _, err := readLoopBackup(b); err == nil {
+			t.Errorf("%s: reading succeeded", name)
+		}
+	}
+
+	// Whatever a damaged byte does, reading has to fail or succeed rather
+	// than panic.
+	b := append([]byte(nil), data...)
+	for i := range b {
+		b[i] ^= 0xff
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Fatalf("flipping byte %d panicked: %v", i, r)
+				}
+			}()
+			f, err := openSQLite(b)
+			if err != nil {
+				return
+			}
+			f.readTable("Habits")
+			f.readTable("Repetitions")
+		}()
+		b[i] ^= 0xff
+	}
+}
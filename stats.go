@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"gioui.org/layout"
+	"gioui.org/widget"
+	"gioui.org/widget/material"
+)
+
+const (
+	heatmapWeeks      = 26
+	heatmapCellWidth  = 12
+	heatmapCellHeight = 10
+)
+
+// statsWindows are the spans of days, ending today, that completion rates
+// are given for.
+var statsWindows = [4]int{7, 30, 90, 365}
+
+// habitStats is the completion history of a single habit.
+type habitStats struct {
+	habit habit
+	// rates holds the completion rate for each of the `statsWindows`, or -1
+	// if the habit wasn't due at all in that span.
+	rates [4]float32
+	// bestDay and worstDay are the weekdays with the highest and lowest
+	// completion rates. They're only meaningful if `hasWeekdays` is set.
+	bestDay     time.Weekday
+	worstDay    time.Weekday
+	hasWeekdays bool
+	// heatmap holds the last `heatmapWeeks` weeks, oldest first.
+	heatmap [heatmapWeeks][7]dayStatus
+}
+
+type dayStatus struct {
+	day    time.Time
+	due    bool
+	credit float32
+}
+
+// statsStart returns the first day that the stats for `today` look back to,
+// so only the daily records from then on are needed.
+func statsStart(today time.Time) time.Time {
+	return today.AddDate(0, 0, 1-statsWindows[len(statsWindows)-1])
+}
+
+// computeStats gathers the completion history of each template habit that
+// hasn't been deleted from the given daily records, keyed by date. They need
+// only go back to `statsStart`.
+func computeStats(templates []habit, records map[string][]habit, today time.Time) []habitStats {
+	var all []habitStats
+	for i := range templates {
+		h := &templates[i]
+		if h.isDeleted() {
+			continue
+		}
+		all = append(all, computeHabitStats(h, records, today))
+	}
+	return all
+}
+
+func computeHabitStats(h *habit, records map[string][]habit, today time.Time) habitStats {
+	stats := habitStats{habit: *h}
+	status := func(day time.Time) dayStatus {
+		due, item := dueOn(h, records, day)
+		ds := dayStatus{day: day, due: due}
+		if item != nil {
+			ds.credit = item.credit()
+		}
+		return ds
+	}
+
+	y, m, d := today.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, today.Location())
+
+	// Completion rates over each window.
+	for w, numDays := range statsWindows {
+		var numDue int
+		var credit float32
+		for i := 0; i < numDays; i++ {
+			ds := status(midnight.AddDate(0, 0, -i))
+			if ds.due {
+				numDue++
+				credit += ds.credit
+			}
+		}
+		stats.rates[w] = -1
+		switch {
+		case h.Schedule.isWeeklyGoal():
+			// Weekly goals are offered every day, so judge them by how much of
+			// the window's goal was met.
+			goal := float32(h.Schedule.PerWeek*numDays) / 7
+			if numDue > 0 {
+				stats.rates[w] = credit / goal
+				if stats.rates[w] > 1 {
+					stats.rates[w] = 1
+				}
+			}
+		case numDue > 0:
+			stats.rates[w] = credit / float32(numDue)
+		}
+	}
+
+	// Best and worst weekdays over the last year.
+	var weekdayDue [7]int
+	var weekdayCredit [7]float32
+	for i := 0; i < 365; i++ {
+		ds := status(midnight.AddDate(0, 0, -i))
+		if ds.due {
+			weekdayDue[ds.day.Weekday()]++
+			weekdayCredit[ds.day.Weekday()] += ds.credit
+		}
+	}
+	var bestRate, worstRate float32 = -1, 2
+	for wd := range weekdayDue {
+		if weekdayDue[wd] == 0 {
+			continue
+		}
+		rate := weekdayCredit[wd] / float32(weekdayDue[wd])
+		if rate > bestRate {
+			bestRate, stats.bestDay = rate, time.Weekday(wd)
+		}
+		if rate < worstRate {
+			worstRate, stats.worstDay = rate, time.Weekday(wd)
+		}
+		stats.hasWeekdays = true
+	}
+
+	// The heatmap ends with the current week.
+	start := weekStart(midnight).AddDate(0, 0, -7*(heatmapWeeks-1))
+	for w := range stats.heatmap {
+		for wd := range stats.heatmap[w] {
+			day := start.AddDate(0, 0, w*7+wd)
+			if day.After(today) {
+				stats.heatmap[w][wd] = dayStatus{day: day}
+				continue
+			}
+			stats.heatmap[w][wd] = status(day)
+		}
+	}
+	return stats
+}
+
+type statsScreen struct {
+	updates chan<- any
+	stats   []habitStats
+	list    widget.List
+	done    widget.Clickable
+}
+
+func (ss *statsScreen) layout(gtx C, th *material.Theme) D {
+	if ss.done.Clicked() {
+		go func() {
+			ss.updates <- closeStatsScreen{}
+		}()
+	}
+	header := func(gtx C) D {
+		heading := func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Rigid(func(gtx C) D {
+					gtx.Constraints.Min.X = 32
+					return iconChart.Layout(gtx, th.Fg)
+				}),
+				layout.Rigid(layout.Spacer{Width: 12}.Layout),
+				layout.Rigid(material.H4(th, "Statistics").Layout),
+			)
+		}
+		return layout.Inset{Top: 25, Right: 15, Bottom: 20, Left: 15}.Layout(gtx, func(gtx C) D {
+			return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+				layout.Flexed(1, heading),
+				layout.Rigid(material.Button(th, &ss.done, "Done").Layout),
+			)
+		})
+	}
+	if len(ss.stats) == 0 {
+		return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+			layout.Rigid(header),
+			layout.Rigid(func(gtx C) D {
+				return layout.UniformInset(20).Layout(gtx, material.Body1(th, "There are no habits to show statistics for yet.").Layout)
+			}),
+		)
+	}
+	return material.List(th, &ss.list).Layout(gtx, len(ss.stats)+1, func(gtx C, i int) D {
+		if i == 0 {
+			return header(gtx)
+		}
+		return layout.Inset{Right: 20, Bottom: 30, Left: 20}.Layout(gtx, func(gtx C) D {
+			return layHabitStats(gtx, th, &ss.stats[i-1])
+		})
+	})
+}
+
+func layHabitStats(gtx C, th *material.Theme, hs *habitStats) D {
+	muted := func(lbl material.LabelStyle) material.LabelStyle {
+		lbl.Color = mutedColor(th.Fg)
+		return lbl
+	}
+	rates := make([]layout.FlexChild, 0, 2*len(statsWindows))
+	for w, numDays := range statsWindows {
+		rate := "n/a"
+		if hs.rates[w] >= 0 {
+			rate = fmt.Sprintf("%.0f%%", hs.rates[w]*100)
+		}
+		lbl := muted(material.Caption(th, fmt.Sprintf("%d days", numDays)))
+		val := material.Body1(th, rate)
+		rates = append(rates, layout.Rigid(func(gtx C) D {
+			return layout.Inset{Right: 30}.Layout(gtx, func(gtx C) D {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(lbl.Layout),
+					layout.Rigid(val.Layout),
+				)
+			})
+		}))
+	}
+	layWeekdays := func(gtx C) D {
+		if !hs.hasWeekdays {
+			return D{}
+		}
+		txt := fmt.Sprintf("Best on %ss, worst on %ss", hs.bestDay, hs.worstDay)
+		return layout.Inset{Top: 8}.Layout(gtx, muted(material.Body2(th, txt)).Layout)
+	}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{Alignment: layout.Baseline}.Layout(gtx,
+				layout.Rigid(material.H6(th, hs.habit.Content).Layout),
+				layout.Rigid(func(gtx C) D {
+					detail := habitDetail(&hs.habit)
+					if detail == "" {
+						return D{}
+					}
+					return layout.Inset{Left: 10}.Layout(gtx, muted(material.Caption(th, detail)).Layout)
+				}),
+			)
+		}),
+		layout.Rigid(layout.Spacer{Height: 8}.Layout),
+		layout.Rigid(func(gtx C) D {
+			return layout.Flex{}.Layout(gtx, rates...)
+		}),
+		layout.Rigid(layWeekdays),
+		layout.Rigid(layout.Spacer{Height: 10}.Layout),
+		layout.Rigid(func(gtx C) D {
+			return layHeatmap(gtx, th, &hs.heatmap)
+		}),
+	)
+}
+
+// layHeatmap draws a habit's recent history with a column per week, using
+// the same cells as the sidebar's day grid.
+func layHeatmap(gtx C, th *material.Theme, weeks *[heatmapWeeks][7]dayStatus) D {
+	var cols [heatmapWeeks]layout.FlexChild
+	for w := range weeks {
+		week := &weeks[w]
+		cols[w] = layout.Rigid(func(gtx C) D {
+			var cells [7]layout.FlexChild
+			for wd := range week {
+				ds := week[wd]
+				cells[wd] = layout.Rigid(func(gtx C) D {
+					return layout.UniformInset(1).Layout(gtx, func(gtx C) D {
+						size := image.Pt(heatmapCellWidth, heatmapCellHeight)
+						if !ds.due {
+							return D{Size: size}
+						}
+						dims := drawSquare(gtx, color.NRGBA{80, 80, 80, 132}, heatmapCellWidth, heatmapCellHeight)
+						clr := th.ContrastBg
+						if ds.credit >= 1 {
+							clr = color.NRGBA{79, 225, 255, 255}
+						} else {
+							clr.A /= 2
+						}
+						drawSquare(gtx, clr, int(heatmapCellWidth*ds.credit), heatmapCellHeight)
+						return dims
+					})
+				})
+			}
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, cells[:]...)
+		})
+	}
+	return layout.Flex{}.Layout(gtx, cols[:]...)
+}
+
+type openStatsScreen struct {
+	stats []habitStats
+}
+
+type closeStatsScreen struct{}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"go.etcd.io/bbolt"
@@ -15,80 +17,382 @@ import (
 //
 // To visualize the bucket/key heirarchy:
 // |---
-// | meta
+// | meta (its sequence is the last habit ID handed out)
+// |   schemaVersion -> int
+// |   dayStart -> int (minutes after midnight that each day begins)
+// |   timezone -> string (the home time zone's name, empty for the system's)
+// |   travelTimezone -> string (the time zone to use while traveling, if any)
+// |   crypt -> cryptHeader (only if the database is encrypted)
 // |   habits -> []habit
+// |   streaks -> map[int]streakTally (see streaks.go)
 // |---
 // | dailyRecords
-// |   [YYMMDD] -> []habit
+// |   [YYYYMMDD] -> []habit
 // |---
 // | dailySummaries
-// |   [YYMMDD] -> dailySummary
+// |   [YYYYMMDD] -> dailySummary
+// |---
+// | weeklySummaries
+// |   [YYYYMMDD of the week's Sunday] -> weeklySummary
 // |---
 type store struct {
 	db *bbolt.DB
 }
 
-func openStore(fpath string) (*store, error) {
-	if fpath == "" {
-		defDir, err := defaultDataDir()
-		if err != nil {
-			return nil, err
-		}
-		fpath = filepath.Join(defDir, "db.todaily")
+// lockTimeout is how long to wait for another process to let go of the
+// database's file lock before giving up with `errStoreLocked`.
+const lockTimeout = 3 * time.Second
+
+// errStoreLocked is returned when another process (e.g. an open Todaily
+// window) is holding the database's file lock.
+type errStoreLocked struct {
+	fpath string
+}
+
+func (e errStoreLocked) Error() string {
+	return fmt.Sprintf("%s is in use by another Todaily process", e.fpath)
+}
+
+// errStoreCorrupt is returned when the database file is too damaged to open.
+type errStoreCorrupt struct {
+	fpath string
+	err   error
+}
+
+func (e errStoreCorrupt) Error() string {
+	return fmt.Sprintf("%s is damaged: %v", e.fpath, e.err)
+}
+
+func (e errStoreCorrupt) Unwrap() error {
+	return e.err
+}
+
+// resolveDBPath returns the database file to use given the one asked for,
+// which may be empty for the default.
+func resolveDBPath(fpath string) (string, error) {
+	if fpath != "" {
+		return filepath.Abs(fpath)
+	}
+	defDir, err := defaultDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(defDir, "db.todaily"), nil
+}
+
+// openStore opens the database at the given path, creating it if needed. If
+// the database is encrypted, its passphrase is asked for with `passphrase`,
+// which may be nil if there's no way to ask (see `errStoreEncrypted`).
+func openStore(fpath string, passphrase func(fpath string) ([]byte, error)) (_ *store, err error) {
+	fpath, err = resolveDBPath(fpath)
+	if err != nil {
+		return nil, err
 	}
 	// Ensure the parent directory exists first and then open the db.
 	if err := os.MkdirAll(path.Dir(fpath), os.ModePerm); err != nil {
 		return nil, err
 	}
-	db, err := bbolt.Open(fpath, 0o644, nil)
-	if err != nil {
-		return nil, fmt.Errorf("opening bolt db: %w", err)
-	}
-	if err := db.Update(func(tx *bbolt.Tx) error {
-		if tx.Bucket([]byte("meta")) != nil {
-			return nil
-		}
-		for _, bucketName := range []string{"meta", "dailyRecords", "dailySummaries"} {
-			_, err := tx.CreateBucket([]byte(bucketName))
-			if err != nil {
-				return fmt.Errorf("creating bucket %q: %w", bucketName, err)
+	// Bolt panics when it comes across a damaged page.
+	var db *bbolt.DB
+	defer func() {
+		if r := recover(); r != nil {
+			if db != nil {
+				ciphers.Delete(db)
+				db.Close()
 			}
+			err = errStoreCorrupt{fpath: fpath, err: fmt.Errorf("%v", r)}
 		}
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("initializing bolt db: %w", err)
+	}()
+	db, err = bbolt.Open(fpath, 0o644, &bbolt.Options{Timeout: lockTimeout})
+	switch {
+	case errors.Is(err, bbolt.ErrTimeout):
+		return nil, errStoreLocked{fpath: fpath}
+	case errors.Is(err, bbolt.ErrInvalid), errors.Is(err, bbolt.ErrChecksum), errors.Is(err, bbolt.ErrVersionMismatch):
+		return nil, errStoreCorrupt{fpath: fpath, err: err}
+	case err != nil:
+		return nil, fmt.Errorf("opening bolt db: %w", err)
+	}
+	if err := unlockDB(db, fpath, passphrase); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(db, fpath); err != nil {
+		ciphers.Delete(db)
+		db.Close()
+		return nil, err
+	}
+	s := &store{db: db}
+	if err := s.loadClock(); err != nil {
+		s.Close()
+		return nil, err
 	}
-	return &store{db: db}, nil
+	return s, nil
 }
 
-func (s *store) getSummaries() (map[string]dailySummary, error) {
+// getSummaries returns the daily summaries from `from` through `to` (as
+// database keys), either of which may be empty to leave that end open.
+func (s *store) getSummaries(from, to string) (map[string]dailySummary, error) {
 	sums := make(map[string]dailySummary)
 	return sums, s.db.View(func(tx *bbolt.Tx) error {
-		tx.Bucket([]byte("dailySummaries")).ForEach(func(k, v []byte) error {
+		b := bucketOf(tx, "dailySummaries")
+		return forEachInRange(b, from, to, func(k, v []byte) error {
 			var summary dailySummary
-			if err := json.Unmarshal(v, &summary); err != nil {
+			if err := decode(b, k, v, &summary); err != nil {
 				return fmt.Errorf("decoding summary for %q: %w", string(k), err)
 			}
 			sums[string(k)] = summary
 			return nil
 		})
+	})
+}
+
+// getWeeklySummaries returns the summaries of the weeks starting from `from`
+// through `to` like `getSummaries`.
+func (s *store) getWeeklySummaries(from, to string) (map[string]weeklySummary, error) {
+	sums := make(map[string]weeklySummary)
+	return sums, s.db.View(func(tx *bbolt.Tx) error {
+		b := bucketOf(tx, "weeklySummaries")
+		return forEachInRange(b, from, to, func(k, v []byte) error {
+			var summary weeklySummary
+			if err := decode(b, k, v, &summary); err != nil {
+				return fmt.Errorf("decoding weekly summary for %q: %w", string(k), err)
+			}
+			sums[string(k)] = summary
+			return nil
+		})
+	})
+}
+
+// forEachInRange calls `fn` with each key and value in the bucket from `from`
+// through `to` in order, where either may be empty to leave that end open.
+// Since keys are YYYYMMDD dates, this only reads the days in the range.
+func forEachInRange(b bucket, from, to string, fn func(k, v []byte) error) error {
+	c := b.Cursor()
+	k, v := c.First()
+	if from != "" {
+		k, v = c.Seek([]byte(from))
+	}
+	for ; k != nil && (to == "" || string(k) <= to); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasRecordsBefore reports whether there are any daily records from before
+// the given date.
+func (s *store) hasRecordsBefore(fmtDate string) (found bool, _ error) {
+	return found, s.db.View(func(tx *bbolt.Tx) error {
+		c := bucketOf(tx, "dailyRecords").Cursor()
+		k, _ := c.Seek([]byte(fmtDate))
+		if k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		found = k != nil && string(k) < fmtDate
+		return nil
+	})
+}
+
+// getWeeklySummary returns the summary of the week that contains the given date.
+func (s *store) getWeeklySummary(fmtDate string) (summary weeklySummary, _ error) {
+	t, err := parseKey(fmtDate)
+	if err != nil {
+		return weeklySummary{}, fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
+	}
+	return summary, s.db.View(func(tx *bbolt.Tx) error {
+		weeklys := bucketOf(tx, "weeklySummaries")
+		if err := get(weeklys, []byte(weekStart(t).Format(keyFormat)), &summary); err != nil {
+			return fmt.Errorf("getting weekly summary for %q: %w", fmtDate, err)
+		}
+		return nil
+	})
+}
+
+// viewWeeklySummary returns the summary of the week that contains the given
+// date as it would be with `items` as that date's record, whether or not the
+// record is stored yet.
+func (s *store) viewWeeklySummary(fmtDate string, items []habit) (summary weeklySummary, _ error) {
+	t, err := parseKey(fmtDate)
+	if err != nil {
+		return weeklySummary{}, fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
+	}
+	return summary, s.db.View(func(tx *bbolt.Tx) error {
+		counts, goals, err := weekCompletions(bucketOf(tx, "dailyRecords"), weekStart(t), fmtDate)
+		if err != nil {
+			return err
+		}
+		addWeeklyGoals(items, counts, goals)
+		summary = newWeeklySummary(counts, goals)
+		return nil
+	})
+}
+
+// getWeekProgress returns how many times each weekly goal habit has been done
+// during the week of the given date, not counting that date itself.
+func (s *store) getWeekProgress(fmtDate string) (counts map[int]int, _ error) {
+	t, err := parseKey(fmtDate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
+	}
+	return counts, s.db.View(func(tx *bbolt.Tx) (err error) {
+		counts, _, err = weekCompletions(bucketOf(tx, "dailyRecords"), weekStart(t), fmtDate)
+		return err
+	})
+}
+
+// getHistory returns the habit template list along with the daily records
+// from `from` through `to` (like `getSummaries`) keyed by date.
+func (s *store) getHistory(from, to string) (templates []habit, records map[string][]habit, _ error) {
+	records = make(map[string][]habit)
+	return templates, records, s.db.View(func(tx *bbolt.Tx) error {
+		if err := get(bucketOf(tx, "meta"), []byte("habits"), &templates); err != nil {
+			return fmt.Errorf("getting habit template list from meta: %w", err)
+		}
+		sortHabits(templates, templates)
+		dailys := bucketOf(tx, "dailyRecords")
+		return forEachInRange(dailys, from, to, func(k, v []byte) error {
+			var items []habit
+			if err := decode(dailys, k, v, &items); err != nil {
+				return fmt.Errorf("decoding habits for %q: %w", string(k), err)
+			}
+			records[string(k)] = items
+			return nil
+		})
+	})
+}
+
+// getStreaks returns the streaks of the template habits with the given IDs,
+// or of every template habit if none are given. Each habit's tally in meta is
+// brought up to date first, so only the daily records since the habits were
+// last tallied are read.
+func (s *store) getStreaks(ids ...int) (streaks map[int]streak, _ error) {
+	today := dayOf(time.Now())
+	return streaks, s.db.Update(func(tx *bbolt.Tx) error {
+		meta := bucketOf(tx, "meta")
+		var templates []habit
+		if err := get(meta, []byte("habits"), &templates); err != nil {
+			return fmt.Errorf("getting habit template list from meta: %w", err)
+		}
+		if len(ids) > 0 {
+			var wanted []habit
+			for i := range templates {
+				for _, id := range ids {
+					if templates[i].ID == id {
+						wanted = append(wanted, templates[i])
+					}
+				}
+			}
+			templates = wanted
+		}
+		tallies := make(map[int]streakTally)
+		if err := get(meta, []byte("streaks"), &tallies); err != nil {
+			return fmt.Errorf("getting streak tallies from meta: %w", err)
+		}
+		// This week's records are always needed for the weekly goals.
+		from := weekStart(today)
+		for i := range templates {
+			if t := tallies[templates[i].ID].tallyFrom(&templates[i], today); t.Before(from) {
+				from = t
+			}
+		}
+		records := make(map[string][]habit)
+		dailys := bucketOf(tx, "dailyRecords")
+		if err := forEachInRange(dailys, from.Format(keyFormat), today.Format(keyFormat), func(k, v []byte) error {
+			var items []habit
+			if err := decode(dailys, k, v, &items); err != nil {
+				return fmt.Errorf("decoding habits for %q: %w", string(k), err)
+			}
+			records[string(k)] = items
+			return nil
+		}); err != nil {
+			return err
+		}
+		streaks = make(map[int]streak, len(templates))
+		for i := range templates {
+			h := &templates[i]
+			t := tallyStreak(h, records, tallies[h.ID], today)
+			tallies[h.ID] = t
+			streaks[h.ID] = t.streak(h, records, today)
+		}
+		if err := put(meta, []byte("streaks"), tallies); err != nil {
+			return fmt.Errorf("putting streak tallies into meta: %w", err)
+		}
 		return nil
 	})
 }
 
+// forgetStreaks drops the streak tallies for which `stale` returns true, so
+// that those habits are tallied again from the start.
+func forgetStreaks(tx *bbolt.Tx, stale func(id int, t streakTally) bool) error {
+	meta := bucketOf(tx, "meta")
+	var tallies map[int]streakTally
+	if err := get(meta, []byte("streaks"), &tallies); err != nil {
+		return fmt.Errorf("getting streak tallies from meta: %w", err)
+	}
+	changed := false
+	for id, t := range tallies {
+		if stale(id, t) {
+			delete(tallies, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return put(meta, []byte("streaks"), tallies)
+}
+
 func (s *store) getHabits() (items []habit, _ error) {
 	return items, s.db.View(func(tx *bbolt.Tx) error {
-		meta := tx.Bucket([]byte("meta"))
+		meta := bucketOf(tx, "meta")
 		if err := get(meta, []byte("habits"), &items); err != nil {
 			return fmt.Errorf("getting habit template list from meta: %w", err)
 		}
+		sortHabits(items, items)
+		return nil
+	})
+}
+
+// newHabitID returns an ID that no other habit has ever had.
+func (s *store) newHabitID() (id int, _ error) {
+	return id, s.db.Update(func(tx *bbolt.Tx) error {
+		seq, err := bucketOf(tx, "meta").NextSequence()
+		if err != nil {
+			return fmt.Errorf("getting next habit ID: %w", err)
+		}
+		id = int(seq)
 		return nil
 	})
 }
 
 func (s *store) putHabits(items []habit) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		meta := tx.Bucket([]byte("meta"))
+		meta := bucketOf(tx, "meta")
+		var old []habit
+		if err := get(meta, []byte("habits"), &old); err != nil {
+			return fmt.Errorf("getting habit template list from meta: %w", err)
+		}
+		// A habit's streaks depend on when it was created and deleted, and
+		// on its schedule for the days without a record.
+		changed := make(map[int]bool)
+		for i := range items {
+			changed[items[i].ID] = true
+		}
+		for i := range old {
+			h := &old[i]
+			for j := range items {
+				if n := &items[j]; n.ID == h.ID && n.CreatedAt.Equal(h.CreatedAt) &&
+					n.DeletedAt.Equal(h.DeletedAt) && n.Schedule == h.Schedule {
+					delete(changed, h.ID)
+				}
+			}
+		}
+		if err := forgetStreaks(tx, func(id int, _ streakTally) bool { return changed[id] }); err != nil {
+			return err
+		}
 		if err := put(meta, []byte("habits"), items); err != nil {
 			return fmt.Errorf("putting habit template list into meta: %w", err)
 		}
@@ -96,84 +400,331 @@ func (s *store) putHabits(items []habit) error {
 	})
 }
 
+// getHabitsForDay returns the habits in the record for the given day, first
+// creating the record from the template list if the day doesn't have one.
 func (s *store) getHabitsForDay(fmtDate string) (items []habit, _ error) {
 	now := time.Now()
-	t, err := time.ParseInLocation("060102", fmtDate, now.Location())
-	if err != nil {
-		return nil, fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
-	}
-	if t.After(now) {
-		return nil, fmt.Errorf("requesting habits for %q, a future date", fmtDate)
-	}
-	return items, s.db.Update(func(tx *bbolt.Tx) (err error) {
-		k := []byte(fmtDate)
-		dailys := tx.Bucket([]byte("dailyRecords"))
-		if dailys.Get(k) == nil {
-			meta := tx.Bucket([]byte("meta"))
-			var templateList []habit
-			if err := get(meta, []byte("habits"), &templateList); err != nil {
-				return fmt.Errorf("reading habit template list: %w", err)
-			}
-			for _, h := range templateList {
-				if h.CreatedAt.Before(t) && (h.DeletedAt.IsZero() || h.DeletedAt.After(t)) {
-					h.CreatedAt = now
-					items = append(items, h)
-				}
-			}
-			if err := put(dailys, k, items); err != nil {
-				return fmt.Errorf("inserting habit data for new day %q: %w", fmtDate, err)
-			}
-			return nil
+	return items, s.db.Update(func(tx *bbolt.Tx) error {
+		var stored bool
+		var err error
+		if items, stored, err = readHabitsForDay(tx, fmtDate, now); err != nil || stored {
+			return err
 		}
-		if err := get(dailys, k, &items); err != nil {
-			return fmt.Errorf("getting habits for %q: %w", fmtDate, err)
+		if err := putDayRecord(tx, fmtDate, items); err != nil {
+			return fmt.Errorf("inserting habit data for new day %q: %w", fmtDate, err)
 		}
 		return nil
 	})
 }
 
+// viewHabitsForDay is `getHabitsForDay` without creating a missing record,
+// for reading a day without changing the database. A day without a record
+// gets the habits that its record would be created with.
+func (s *store) viewHabitsForDay(fmtDate string) (items []habit, _ error) {
+	now := time.Now()
+	return items, s.db.View(func(tx *bbolt.Tx) (err error) {
+		items, _, err = readHabitsForDay(tx, fmtDate, now)
+		return err
+	})
+}
+
+// readHabitsForDay returns the habits in the record for the given day, or the
+// ones from the template list that a new record would have as of `now` if
+// there's no record (in which case `stored` is false).
+func readHabitsForDay(tx *bbolt.Tx, fmtDate string, now time.Time) (items []habit, stored bool, _ error) {
+	t, err := parseKey(fmtDate)
+	if err != nil {
+		return nil, false, fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
+	}
+	if t.After(dayOf(now)) {
+		return nil, false, fmt.Errorf("requesting habits for %q, a future date", fmtDate)
+	}
+	k := []byte(fmtDate)
+	dailys := bucketOf(tx, "dailyRecords")
+	var templateList []habit
+	if err := get(bucketOf(tx, "meta"), []byte("habits"), &templateList); err != nil {
+		return nil, false, fmt.Errorf("reading habit template list: %w", err)
+	}
+	sortHabits(templateList, templateList)
+	if dailys.Get(k) == nil {
+		return newDayItems(templateList, t, now), false, nil
+	}
+	if err := get(dailys, k, &items); err != nil {
+		return nil, false, fmt.Errorf("getting habits for %q: %w", fmtDate, err)
+	}
+	sortHabits(items, templateList)
+	return items, true, nil
+}
+
+// applyTemplateToDay rebuilds the record for the given day from the given
+// template list (see `mergeTemplate`), saves it and returns it.
+func (s *store) applyTemplateToDay(fmtDate string, templates []habit) ([]habit, error) {
+	dayItems, err := s.getHabitsForDay(fmtDate)
+	if err != nil {
+		return nil, err
+	}
+	resolved := mergeTemplate(templates, dayItems, time.Now())
+	if err := s.putHabitsForDay(fmtDate, resolved); err != nil {
+		return nil, fmt.Errorf("saving habits for %q: %w", fmtDate, err)
+	}
+	return resolved, nil
+}
+
+// newDayItems returns the habits from the template list that belong in the
+// record for the day starting at `t`, as of `now`.
+func newDayItems(templates []habit, t, now time.Time) []habit {
+	var items []habit
+	for _, h := range templates {
+		if h.isActiveOn(t) {
+			h.CreatedAt = now
+			items = append(items, h)
+		}
+	}
+	return items
+}
+
 func (s *store) putHabitsForDay(fmtDate string, items []habit) error {
 	return s.db.Update(func(tx *bbolt.Tx) error {
-		k := []byte(fmtDate)
-		dailys := tx.Bucket([]byte("dailyRecords"))
-		if err := put(dailys, k, items); err != nil {
+		if err := putDayRecord(tx, fmtDate, items); err != nil {
 			return err
 		}
-		sums := tx.Bucket([]byte("dailySummaries"))
-		summary := newSummaryOfList(items)
-		if err := put(sums, k, summary); err != nil {
-			return fmt.Errorf("setting completion status for %q: %w", fmtDate, err)
+		return forgetStreaks(tx, func(_ int, t streakTally) bool { return t.Through >= fmtDate })
+	})
+}
+
+// putDayRecord writes the record for the given day along with its daily
+// summary and the summary of its week.
+func putDayRecord(tx *bbolt.Tx, fmtDate string, items []habit) error {
+	k := []byte(fmtDate)
+	if err := put(bucketOf(tx, "dailyRecords"), k, items); err != nil {
+		return err
+	}
+	if err := put(bucketOf(tx, "dailySummaries"), k, newSummaryOfList(items)); err != nil {
+		return fmt.Errorf("setting completion status for %q: %w", fmtDate, err)
+	}
+	if err := updateWeeklySummary(tx, fmtDate); err != nil {
+		return fmt.Errorf("updating weekly summary for %q: %w", fmtDate, err)
+	}
+	return nil
+}
+
+// rebuildSummaries recomputes every daily and weekly summary from the daily
+// records and drops the streak tallies so they're recounted. It fails if any
+// record can't be decoded.
+func rebuildSummaries(tx *bbolt.Tx) error {
+	return rebuildSummariesWith(tx, func(k []byte, err error) error {
+		return fmt.Errorf("reading habits for %q: %w", string(k), err)
+	})
+}
+
+// rebuildSummariesWith is `rebuildSummaries` except that it passes the key of
+// each record that can't be decoded to `damaged` and leaves the record out,
+// unless `damaged` returns an error.
+func rebuildSummariesWith(tx *bbolt.Tx, damaged func(k []byte, err error) error) error {
+	meta, dailys := bucketOf(tx, "meta"), bucketOf(tx, "dailyRecords")
+	if meta.Bucket == nil || dailys.Bucket == nil {
+		return errors.New("the meta and dailyRecords buckets are needed")
+	}
+	if err := meta.Delete([]byte("streaks")); err != nil {
+		return fmt.Errorf("clearing streak tallies: %w", err)
+	}
+	records := make(map[string][]habit)
+	weeks := make(map[string]time.Time)
+	if err := dailys.ForEach(func(k, v []byte) error {
+		t, err := parseKey(string(k))
+		if err != nil {
+			return damaged(k, err)
 		}
+		var items []habit
+		if err := decode(dailys, k, v, &items); err != nil {
+			return damaged(k, err)
+		}
+		records[string(k)] = items
+		weeks[weekStart(t).Format(keyFormat)] = weekStart(t)
 		return nil
+	}); err != nil {
+		return err
+	}
+	for _, name := range []string{"dailySummaries", "weeklySummaries"} {
+		if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+			return fmt.Errorf("clearing bucket %q: %w", name, err)
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return fmt.Errorf("creating bucket %q: %w", name, err)
+		}
+	}
+	sums := bucketOf(tx, "dailySummaries")
+	for fmtDate, items := range records {
+		if err := put(sums, []byte(fmtDate), newSummaryOfList(items)); err != nil {
+			return fmt.Errorf("setting completion status for %q: %w", fmtDate, err)
+		}
+	}
+	weeklys := bucketOf(tx, "weeklySummaries")
+	for k, start := range weeks {
+		if err := put(weeklys, []byte(k), recordsWeeklySummary(records, start)); err != nil {
+			return fmt.Errorf("setting weekly summary for %q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// updateWeeklySummary recomputes the summary of the week containing the given
+// date from that week's daily records.
+func updateWeeklySummary(tx *bbolt.Tx, fmtDate string) error {
+	t, err := parseKey(fmtDate)
+	if err != nil {
+		return fmt.Errorf("parsing fmtDate %q: %w", fmtDate, err)
+	}
+	start := weekStart(t)
+	counts, goals, err := weekCompletions(bucketOf(tx, "dailyRecords"), start, "")
+	if err != nil {
+		return err
+	}
+	return put(bucketOf(tx, "weeklySummaries"), []byte(start.Format(keyFormat)), newWeeklySummary(counts, goals))
+}
+
+// weekCompletions reads the daily records of the week beginning on `start`
+// (skipping the `skip` date, if any) and returns how many times each weekly
+// goal habit was done along with each one's goal for the week.
+func weekCompletions(dailys bucket, start time.Time, skip string) (counts, goals map[int]int, _ error) {
+	counts = make(map[int]int)
+	goals = make(map[int]int)
+	for i := 0; i < 7; i++ {
+		fmtDate := start.AddDate(0, 0, i).Format(keyFormat)
+		if fmtDate == skip {
+			continue
+		}
+		var items []habit
+		if err := get(dailys, []byte(fmtDate), &items); err != nil {
+			return nil, nil, fmt.Errorf("getting habits for %q: %w", fmtDate, err)
+		}
+		addWeeklyGoals(items, counts, goals)
+	}
+	return counts, goals, nil
+}
+
+// addWeeklyGoals adds the weekly goal habits in a day's record to the counts
+// of how many times each was done and to their goals for the week.
+func addWeeklyGoals(items []habit, counts, goals map[int]int) {
+	for _, h := range items {
+		if !h.Schedule.isWeeklyGoal() {
+			continue
+		}
+		goals[h.ID] = h.Schedule.PerWeek
+		if h.isDone() {
+			counts[h.ID]++
+		}
+	}
+}
+
+// newWeeklySummary tallies how many of the given weekly goals were met.
+func newWeeklySummary(counts, goals map[int]int) weeklySummary {
+	var summary weeklySummary
+	for id, perWeek := range goals {
+		summary.NumGoals++
+		if counts[id] >= perWeek {
+			summary.NumMet++
+		}
+	}
+	return summary
+}
+
+// sortHabits orders the given habits by the positions of their counterparts
+// in the (possibly same) template list. Habits missing from the template list
+// go last.
+func sortHabits(items, templates []habit) {
+	positions := make(map[int]int, len(templates))
+	for _, h := range templates {
+		positions[h.ID] = h.Pos
+	}
+	pos := func(h habit) int {
+		if p, ok := positions[h.ID]; ok {
+			return p
+		}
+		return len(templates)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return pos(items[i]) < pos(items[j])
 	})
 }
 
 func (s *store) Close() error {
+	ciphers.Delete(s.db)
 	return s.db.Close()
 }
 
-func put(b *bbolt.Bucket, key []byte, v any) error {
+// bucket is one of the database's buckets along with its name, which values
+// in an encrypted database are sealed with.
+type bucket struct {
+	*bbolt.Bucket
+	name string
+}
+
+func bucketOf(tx *bbolt.Tx, name string) bucket {
+	return bucket{tx.Bucket([]byte(name)), name}
+}
+
+// put writes `v` as JSON under the given key, encrypted if the database is.
+func put(b bucket, key []byte, v any) error {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return fmt.Errorf("encoding to json: %w", err)
 	}
+	if c := cipherOf(b); c != nil {
+		if data, err = c.seal(data, valueAD(b.name, key)); err != nil {
+			return err
+		}
+	}
 	if err := b.Put(key, data); err != nil {
 		return fmt.Errorf("writing json blob: %w", err)
 	}
 	return nil
 }
 
-func get(b *bbolt.Bucket, key []byte, v any) error {
+// get reads the value under the given key into `v`, leaving `v` as it is if
+// there's no such key.
+func get(b bucket, key []byte, v any) error {
 	data := b.Get(key)
 	if len(data) == 0 {
 		return nil
 	}
-	if err := json.Unmarshal(data, v); err != nil {
-		return err
+	return decode(b, key, data, v)
+}
+
+// decode reads a value from under the given key in the given bucket (as it was
+// written by `put`) into `v`.
+func decode(b bucket, key, data []byte, v any) error {
+	if c := cipherOf(b); c != nil {
+		plain, err := c.open(data, valueAD(b.name, key))
+		if err != nil {
+			return err
+		}
+		data = plain
+	}
+	return json.Unmarshal(data, v)
+}
+
+// putPlain and getPlain are `put` and `get` for the few values in meta that
+// are never encrypted.
+func putPlain(b bucket, key []byte, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding to json: %w", err)
+	}
+	if err := b.Put(key, data); err != nil {
+		return fmt.Errorf("writing json blob: %w", err)
 	}
 	return nil
 }
 
+func getPlain(b bucket, key []byte, v any) error {
+	data := b.Get(key)
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
 // defaultDataDir returns the default location for storing app data
 // which is a directory named `.todaily` in the user's home directory.
 func defaultDataDir() (string, error) {
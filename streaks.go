@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// streak is how many times in a row a habit has been done when it was due.
+// Weekly goal habits count their streaks in weeks whose goal was met.
+type streak struct {
+	Current int  `json:"current"`
+	Longest int  `json:"longest"`
+	Weekly  bool `json:"weekly,omitempty"`
+}
+
+func (st streak) String() string {
+	if st.Longest == 0 {
+		return ""
+	}
+	if st.Weekly {
+		return fmt.Sprintf("%d weeks in a row, best %d", st.Current, st.Longest)
+	}
+	return fmt.Sprintf("%d in a row, best %d", st.Current, st.Longest)
+}
+
+// streakTally is how a habit's streaks stood at the end of the day `Through`,
+// which for weekly goal habits is always the last day of a week. The tallies
+// are kept in meta so that streaks can be brought up to date from the days
+// since instead of being recounted from the whole history (see `getStreaks`).
+type streakTally struct {
+	Through string `json:"through"`
+	Run     int    `json:"run"`
+	Longest int    `json:"longest"`
+}
+
+// tallyFrom returns the first day that tally `t` of template habit `h` still
+// needs the daily records from, which is the day the habit was created (or
+// the start of that week) if nothing has been tallied yet. A tally that has
+// gotten ahead of `today`, such as after a change of time zone, is started
+// over.
+func (t streakTally) tallyFrom(h *habit, today time.Time) time.Time {
+	latest := today
+	if h.Schedule.isWeeklyGoal() {
+		latest = weekStart(today)
+	}
+	if through, err := parseKey(t.Through); err == nil && through.Before(latest) {
+		return through.AddDate(0, 0, 1)
+	}
+	created := dayOf(h.CreatedAt)
+	if h.Schedule.isWeeklyGoal() {
+		return weekStart(created)
+	}
+	return created
+}
+
+// tallyStreak brings the tally of template habit `h` up to date through the
+// day before `today` (or, for weekly goal habits, through the week before
+// this one) from the given daily records, keyed by date. Only days the habit
+// was due on count (see `dueOn`).
+func tallyStreak(h *habit, records map[string][]habit, t streakTally, today time.Time) streakTally {
+	day := t.tallyFrom(h, today)
+	if t.Through >= day.Format(keyFormat) {
+		t = streakTally{}
+	}
+	if h.Schedule.isWeeklyGoal() {
+		for ; !day.AddDate(0, 0, 7).After(today); day = day.AddDate(0, 0, 7) {
+			t.Through = day.AddDate(0, 0, 6).Format(keyFormat)
+			if h.isDeletedBy(day) {
+				continue
+			}
+			if weekDoneCount(h, records, day, 7) >= h.Schedule.PerWeek {
+				t.count()
+			} else {
+				t.Run = 0
+			}
+		}
+		return t
+	}
+	for ; day.Before(today); day = day.AddDate(0, 0, 1) {
+		t.Through = day.Format(keyFormat)
+		if h.isDeletedBy(day) {
+			continue
+		}
+		due, item := dueOn(h, records, day)
+		switch {
+		case !due:
+		case item != nil && item.isDone():
+			t.count()
+		default:
+			t.Run = 0
+		}
+	}
+	return t
+}
+
+func (t *streakTally) count() {
+	t.Run++
+	if t.Run > t.Longest {
+		t.Longest = t.Run
+	}
+}
+
+// streak returns the streaks of template habit `h` as of `today` given its
+// tally through the day (or week) before. Not having done the habit yet today
+// (or reached its goal yet this week) doesn't break the current streak.
+func (t streakTally) streak(h *habit, records map[string][]habit, today time.Time) streak {
+	st := streak{Current: t.Run, Longest: t.Longest, Weekly: h.Schedule.isWeeklyGoal()}
+	var doneNow bool
+	if st.Weekly {
+		week := weekStart(today)
+		doneNow = !h.isDeletedBy(week) &&
+			weekDoneCount(h, records, week, daysBetween(week, today)+1) >= h.Schedule.PerWeek
+	} else if !h.isDeletedBy(today) {
+		due, item := dueOn(h, records, today)
+		doneNow = due && item != nil && item.isDone()
+	}
+	if doneNow {
+		st.Current++
+		if st.Current > st.Longest {
+			st.Longest = st.Current
+		}
+	}
+	return st
+}
+
+// computeStreak tallies the streaks of template habit `h` from the day it was
+// created up to `today` from the given daily records, keyed by date.
+func computeStreak(h *habit, records map[string][]habit, today time.Time) streak {
+	return tallyStreak(h, records, streakTally{}, today).streak(h, records, today)
+}
+
+// dueOn reports whether template habit `h` was due on the given day according
+// to the daily records, keyed by date. A day that has a record only counts if
+// the habit was part of it, which respects schedules and any changes to them
+// over time. A day without a record falls back to whether the habit's
+// schedule has it due. It also returns the habit's entry in that day's
+// record, if there is one.
+func dueOn(h *habit, records map[string][]habit, day time.Time) (bool, *habit) {
+	items, ok := records[day.Format(keyFormat)]
+	if !ok {
+		return h.isActiveOn(day), nil
+	}
+	for i := range items {
+		if items[i].ID == h.ID {
+			return true, &items[i]
+		}
+	}
+	return false, nil
+}
+
+// weekDoneCount returns how many of the first `n` days of the week starting
+// on `week` template habit `h` was done on according to the daily records,
+// keyed by date.
+func weekDoneCount(h *habit, records map[string][]habit, week time.Time, n int) int {
+	numDone := 0
+	for i := 0; i < n; i++ {
+		items := records[week.AddDate(0, 0, i).Format(keyFormat)]
+		for j := range items {
+			if items[j].ID == h.ID && items[j].isDone() {
+				numDone++
+			}
+		}
+	}
+	return numDone
+}
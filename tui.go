@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// The terminal UI mirrors the home and habit screens for terminals, drawing
+// the day grid with block characters and 256 color escape codes.
+
+type tuiMode int
+
+const (
+	tuiHome tuiMode = iota
+	tuiHabits
+)
+
+type tui struct {
+	store   *store
+	mode    tuiMode
+	width   int
+	height  int
+	message string
+	prompt  *tuiPrompt
+	// The home screen: the day grid and the checklist for the selected day.
+	grid      []gridRow
+	fmtDate   string
+	items     []habit
+	weekCompl map[int]int
+	streaks   map[int]streak
+	cursor    int
+	// The habit management screen.
+	templates   []habit
+	habitCursor int
+}
+
+// tuiPrompt is a line of text being entered at the bottom of the screen.
+type tuiPrompt struct {
+	label string
+	text  []rune
+	done  func(text string)
+}
+
+func runTUI(env *cmdEnv, args []string) error {
+	fs, dbFile := newCommandFlags(env, "tui")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return errors.New("not running in a terminal")
+	}
+	return withStore(*dbFile, func(s *store) error {
+		t := tui{store: s}
+		if err := t.selectDay(todayKey()); err != nil {
+			return err
+		}
+		return t.run()
+	})
+}
+
+func (t *tui) run() error {
+	fd := int(os.Stdin.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("putting the terminal into raw mode: %w", err)
+	}
+	defer term.Restore(fd, oldState)
+
+	out := bufio.NewWriter(os.Stdout)
+	// Switch to the alternate screen and hide the cursor.
+	out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		out.WriteString("\x1b[?25h\x1b[?1049l")
+		out.Flush()
+	}()
+
+	input := make(chan []byte)
+	go func() {
+		for {
+			buf := make([]byte, 64)
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				close(input)
+				return
+			}
+			input <- buf[:n]
+		}
+	}()
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+	defer signal.Stop(resized)
+	// Move on to the new day when it starts, checking regularly in case the
+	// clock jumps, the same as `watchForNewDay` does for the window.
+	today := todayKey()
+	untilDayCheck := func() time.Duration {
+		now := time.Now()
+		if wait := nextDayStart(now).Sub(now); wait < dayCheckInterval {
+			return wait
+		}
+		return dayCheckInterval
+	}
+	dayCheck := time.NewTimer(untilDayCheck())
+	defer dayCheck.Stop()
+
+	for {
+		t.width, t.height, err = term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			t.width, t.height = 80, 24
+		}
+		t.draw(out)
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		select {
+		case b, ok := <-input:
+			if !ok {
+				return nil
+			}
+			for _, k := range decodeKeys(b) {
+				if t.handleKey(k) {
+					return nil
+				}
+			}
+		case <-resized:
+		case <-dayCheck.C:
+			if k := todayKey(); k != today {
+				t.setError("starting the new day", t.rollOver(today, k))
+				today = k
+			}
+			dayCheck.Reset(untilDayCheck())
+		}
+	}
+}
+
+// decodeKeys splits terminal input into key names: "up", "down", "left",
+// "right", "enter", "esc", "backspace", "ctrl-c", or the typed character.
+func decodeKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch {
+		case b[0] == 0x1b && len(b) > 2 && (b[1] == '[' || b[1] == 'O'):
+			// A control sequence ends with a byte in the range 0x40-0x7e.
+			i := 2
+			for i < len(b)-1 && (b[i] < 0x40 || b[i] > 0x7e) {
+				i++
+			}
+			switch b[i] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			case 'C':
+				keys = append(keys, "right")
+			case 'D':
+				keys = append(keys, "left")
+			}
+			b = b[i+1:]
+			continue
+		case b[0] == 0x1b:
+			keys = append(keys, "esc")
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, "enter")
+		case b[0] == 0x7f || b[0] == 0x08:
+			keys = append(keys, "backspace")
+		case b[0] == 0x03:
+			keys = append(keys, "ctrl-c")
+		default:
+			r, size := utf8.DecodeRune(b)
+			keys = append(keys, string(r))
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+// handleKey applies a key press and reports whether the UI should quit.
+func (t *tui) handleKey(k string) bool {
+	if k == "ctrl-c" {
+		return true
+	}
+	if t.prompt != nil {
+		t.handlePromptKey(k)
+		return false
+	}
+	t.message = ""
+	if t.mode == tuiHabits {
+		t.handleHabitsKey(k)
+		return false
+	}
+	return t.handleHomeKey(k)
+}
+
+func (t *tui) handlePromptKey(k string) {
+	p := t.prompt
+	switch k {
+	case "esc":
+		t.prompt = nil
+	case "enter":
+		t.prompt = nil
+		p.done(strings.TrimSpace(string(p.text)))
+	case "backspace":
+		if len(p.text) > 0 {
+			p.text = p.text[:len(p.text)-1]
+		}
+	default:
+		if utf8.RuneCountInString(k) == 1 {
+			p.text = append(p.text, []rune(k)...)
+		}
+	}
+}
+
+func (t *tui) handleHomeKey(k string) bool {
+	visible := t.visibleItems()
+	switch k {
+	case "q", "esc":
+		return true
+	case "up", "k":
+		if t.cursor > 0 {
+			t.cursor--
+		}
+	case "down", "j":
+		if t.cursor < len(visible)-1 {
+			t.cursor++
+		}
+	case "left", "h", "right", "l":
+		days := -1
+		if k == "right" || k == "l" {
+			days = 1
+		}
+		day, _ := parseKey(t.fmtDate)
+		fmtDate := day.AddDate(0, 0, days).Format(keyFormat)
+		if fmtDate > todayKey() || fmtDate < t.grid[0].cells[0].fmtDate {
+			break
+		}
+		t.setError("selecting day", t.selectDay(fmtDate))
+	case "t":
+		t.setError("selecting day", t.selectDay(todayKey()))
+	case " ", "enter", "x", "+", "-":
+		if len(visible) == 0 {
+			break
+		}
+		item := &t.items[visible[t.cursor]]
+		now := time.Now()
+		switch {
+		case item.isQuantity() && k == "-":
+			item.setValue(item.Value-1, now)
+		case item.isQuantity() && k == "+":
+			item.setValue(item.Value+1, now)
+		case item.isQuantity() && item.isDone():
+			item.setValue(0, now)
+		case item.isQuantity():
+			item.setValue(item.Target, now)
+		case k == "-" || k == "+":
+			return false
+		case item.isDone():
+			item.CompletedAt = time.Time{}
+		default:
+			item.CompletedAt = now
+		}
+		t.setError("saving habits", t.saveDay())
+	case "=":
+		if len(visible) == 0 || !t.items[visible[t.cursor]].isQuantity() {
+			break
+		}
+		i := visible[t.cursor]
+		t.prompt = &tuiPrompt{label: "Amount for " + t.items[i].Content + ": ", done: func(text string) {
+			v, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				t.message = fmt.Sprintf("%q is not a number", text)
+				return
+			}
+			t.items[i].setValue(v, time.Now())
+			t.setError("saving habits", t.saveDay())
+		}}
+	case "m":
+		templates, err := t.store.getHabits()
+		if err != nil {
+			t.setError("reading habits", err)
+			break
+		}
+		t.templates = templates
+		t.habitCursor = 0
+		t.mode = tuiHabits
+	}
+	return false
+}
+
+func (t *tui) handleHabitsKey(k string) {
+	var cur *habit
+	if t.habitCursor < len(t.templates) {
+		cur = &t.templates[t.habitCursor]
+	}
+	switch k {
+	case "q", "esc":
+		t.mode = tuiHome
+	case "t":
+		if _, err := t.store.applyTemplateToDay(todayKey(), t.templates); err != nil {
+			t.setError("applying habits to today", err)
+			break
+		}
+		t.setError("reloading today", t.selectDay(t.fmtDate))
+		t.message = "Applied the habit list to today."
+	case "up", "k":
+		if t.habitCursor > 0 {
+			t.habitCursor--
+		}
+	case "down", "j":
+		if t.habitCursor < len(t.templates)-1 {
+			t.habitCursor++
+		}
+	case "K", "J":
+		to := t.habitCursor - 1
+		if k == "J" {
+			to = t.habitCursor + 1
+		}
+		if cur == nil || to < 0 || to >= len(t.templates) {
+			break
+		}
+		t.templates[t.habitCursor], t.templates[to] = t.templates[to], t.templates[t.habitCursor]
+		t.habitCursor = to
+		t.saveHabits()
+	case "a":
+		t.promptNewHabit()
+	case "e":
+		if cur == nil || cur.isDeleted() {
+			break
+		}
+		t.prompt = &tuiPrompt{label: "Habit name: ", text: []rune(cur.Content), done: func(text string) {
+			if text != "" {
+				cur.Content = text
+				t.saveHabits()
+			}
+		}}
+	case "d":
+		if cur == nil || cur.isDeleted() {
+			break
+		}
+		cur.DeletedAt = time.Now()
+		t.saveHabits()
+		t.message = fmt.Sprintf("Deleted %q. Press r to restore it.", cur.Content)
+	case "r":
+		if cur == nil || !cur.isDeleted() {
+			break
+		}
+		cur.DeletedAt = time.Time{}
+		t.saveHabits()
+	}
+}
+
+// promptNewHabit asks for a new habit's name, schedule and daily target in turn.
+func (t *tui) promptNewHabit() {
+	var h habit
+	askTarget := func() {
+		t.prompt = &tuiPrompt{label: "Daily target (e.g. 8 glasses, blank for none): ", done: func(text string) {
+			fields := strings.Fields(text)
+			if len(fields) > 0 {
+				n, err := strconv.ParseFloat(fields[0], 64)
+				if err != nil || n <= 0 {
+					t.message = fmt.Sprintf("%q is not a valid target", fields[0])
+					return
+				}
+				h.Target = n
+				h.Unit = strings.Join(fields[1:], " ")
+			}
+			id, err := t.store.newHabitID()
+			if err != nil {
+				t.setError("adding habit", err)
+				return
+			}
+			h.ID = id
+			h.CreatedAt = time.Now()
+			t.templates = append(t.templates, h)
+			t.habitCursor = len(t.templates) - 1
+			t.saveHabits()
+		}}
+	}
+	askSchedule := func() {
+		t.prompt = &tuiPrompt{label: "Schedule (daily, weekdays, Mon,Wed, every 3, 2 per week): ", done: func(text string) {
+			sched, err := parseScheduleText(text)
+			if err != nil {
+				t.message = err.Error()
+				return
+			}
+			h.Schedule = sched
+			askTarget()
+		}}
+	}
+	t.prompt = &tuiPrompt{label: "New habit: ", done: func(text string) {
+		if text == "" {
+			return
+		}
+		h.Content = text
+		askSchedule()
+	}}
+}
+
+// parseScheduleText parses a schedule written as "daily", "weekdays", a list
+// of weekdays like "Mon,Wed", "every N" (days) or "N per week".
+func parseScheduleText(text string) (schedule, error) {
+	fields := strings.Fields(strings.ToLower(text))
+	switch {
+	case len(fields) == 0 || (len(fields) == 1 && fields[0] == "daily"):
+		return schedule{}, nil
+	case len(fields) == 1 && fields[0] == "weekdays":
+		return schedule{Kind: scheduleWeekdays, Weekdays: workWeek}, nil
+	case len(fields) == 2 && fields[0] == "every":
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			return schedule{}, fmt.Errorf("%q is not a valid number of days", fields[1])
+		}
+		return schedule{Kind: scheduleInterval, Interval: n}, nil
+	case len(fields) == 3 && fields[1] == "per" && fields[2] == "week":
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n < 1 || n > 7 {
+			return schedule{}, fmt.Errorf("%q is not a valid number of times a week", fields[0])
+		}
+		return schedule{Kind: scheduleWeekly, PerWeek: n}, nil
+	}
+	sched := schedule{Kind: scheduleWeekdays}
+	for _, name := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name = strings.ToUpper(name[:1]) + strings.ToLower(name[1:])
+		d, ok := parseWeekday(name)
+		if !ok {
+			return schedule{}, fmt.Errorf("unknown schedule %q", text)
+		}
+		sched.Weekdays = sched.Weekdays.with(d)
+	}
+	return sched, nil
+}
+
+// selectDay loads the given day's habits along with everything shown around
+// them.
+func (t *tui) selectDay(fmtDate string) error {
+	items, err := t.store.getHabitsForDay(fmtDate)
+	if err != nil {
+		return err
+	}
+	weekCompl, err := t.store.getWeekProgress(fmtDate)
+	if err != nil {
+		return err
+	}
+	if fmtDate != t.fmtDate {
+		t.cursor = 0
+	}
+	t.fmtDate, t.items, t.weekCompl = fmtDate, items, weekCompl
+	if n := len(t.visibleItems()); t.cursor >= n {
+		t.cursor = n - 1
+		if n == 0 {
+			t.cursor = 0
+		}
+	}
+	return t.refresh()
+}
+
+// rollOver starts the record for the new day, selecting it instead if the
+// previous day was selected.
+func (t *tui) rollOver(prev, today string) error {
+	if t.fmtDate == prev {
+		return t.selectDay(today)
+	}
+	if _, err := t.store.getHabitsForDay(today); err != nil {
+		return err
+	}
+	return t.refresh()
+}
+
+// refresh reloads the grid and streaks.
+func (t *tui) refresh() error {
+	today := dayOf(time.Now())
+	grid, _, err := loadDayGrid(t.store, gridStart(today), today)
+	if err != nil {
+		return err
+	}
+	streaks, err := t.store.getStreaks()
+	if err != nil {
+		return err
+	}
+	t.grid = grid
+	t.streaks = streaks
+	return nil
+}
+
+func (t *tui) saveDay() error {
+	if err := t.store.putHabitsForDay(t.fmtDate, t.items); err != nil {
+		return err
+	}
+	return t.refresh()
+}
+
+func (t *tui) saveHabits() {
+	for i := range t.templates {
+		t.templates[i].Pos = i
+	}
+	t.setError("saving habits", t.store.putHabits(t.templates))
+}
+
+func (t *tui) setError(desc string, err error) {
+	if err != nil {
+		t.message = fmt.Sprintf("Error %s: %v", desc, err)
+	}
+}
+
+// visibleItems returns the indexes of the selected day's habits that are
+// shown. Like the home screen, weekly goals that have already been met on
+// other days of the week are hidden.
+func (t *tui) visibleItems() []int {
+	var visible []int
+	for i := range t.items {
+		item := &t.items[i]
+		if item.Schedule.isWeeklyGoal() && !item.isDone() && t.weekCompl[item.ID] >= item.Schedule.PerWeek {
+			continue
+		}
+		visible = append(visible, i)
+	}
+	return visible
+}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiMuted   = "\x1b[2m"
+	ansiReverse = "\x1b[7m"
+)
+
+// cellColor returns the 256 color palette index for a grid cell with the
+// given completion percentage, going from grey through shades of the theme's
+// blue to the bright blue of a completed day.
+func cellColor(pct float32) int {
+	switch {
+	case pct <= 0:
+		return 238
+	case pct < 0.34:
+		return 24
+	case pct < 0.67:
+		return 31
+	case pct < 1:
+		return 38
+	}
+	return 51
+}
+
+func (t *tui) draw(out *bufio.Writer) {
+	var lines []string
+	switch t.mode {
+	case tuiHome:
+		lines = t.homeLines()
+	case tuiHabits:
+		lines = t.habitLines()
+	}
+	// Keep the prompt or message and the key help on the last lines.
+	var footer []string
+	if t.prompt != nil {
+		footer = append(footer, ansiBold+t.prompt.label+ansiReset+string(t.prompt.text)+ansiReverse+" "+ansiReset)
+	} else if t.message != "" {
+		footer = append(footer, t.message)
+	}
+	if t.mode == tuiHome {
+		footer = append(footer, ansiMuted+"j/k move  space check  +/- amount  = enter amount  h/l day  t today  m manage habits  q quit"+ansiReset)
+	} else {
+		footer = append(footer, ansiMuted+"j/k move  J/K reorder  a add  e rename  d delete  r restore  t apply to today  q back"+ansiReset)
+	}
+	if room := t.height - len(footer); len(lines) > room {
+		lines = lines[:room]
+	}
+	for len(lines)+len(footer) < t.height {
+		lines = append(lines, "")
+	}
+	lines = append(lines, footer...)
+
+	out.WriteString("\x1b[H")
+	for i, ln := range lines {
+		out.WriteString(ln + "\x1b[K")
+		if i < len(lines)-1 {
+			out.WriteString("\r\n")
+		}
+	}
+}
+
+// homeLines draws the day grid with a column per week, like the sidebar turned
+// on its side, followed by the selected day's habits.
+func (t *tui) homeLines() []string {
+	rows := t.grid
+	if maxWeeks := (t.width - 6) / 2; len(rows) > maxWeeks && maxWeeks > 0 {
+		rows = rows[len(rows)-maxWeeks:]
+	}
+	today := todayKey()
+
+	months := []rune(strings.Repeat(" ", 4+2*len(rows)+3))
+	for w := range rows {
+		if rows[w].monthText != "" {
+			copy(months[4+2*w:], []rune(rows[w].monthText))
+		}
+	}
+	lines := []string{"", string(months)}
+	for wd := 0; wd < 7; wd++ {
+		var b strings.Builder
+		label := "   "
+		if wd%2 == 1 {
+			label = time.Weekday(wd).String()[:3]
+		}
+		b.WriteString(ansiMuted + label + ansiReset + " ")
+		for w := range rows {
+			cell := &rows[w].cells[wd]
+			if cell.fmtDate > today {
+				b.WriteString("  ")
+				continue
+			}
+			clr := cellColor(cell.summary.PctCompl)
+			if cell.fmtDate == t.fmtDate {
+				fmt.Fprintf(&b, "\x1b[48;5;%dm"+ansiBold+"<>"+ansiReset, clr)
+				continue
+			}
+			fmt.Fprintf(&b, "\x1b[38;5;%dm██"+ansiReset, clr)
+		}
+		lines = append(lines, b.String())
+	}
+	// Mark the weeks where every weekly goal was met.
+	var marks strings.Builder
+	marks.WriteString("    ")
+	for w := range rows {
+		switch {
+		case rows[w].week.isMet():
+			marks.WriteString("\x1b[38;5;51m▀▀" + ansiReset)
+		case rows[w].week.NumGoals > 0:
+			marks.WriteString("\x1b[38;5;238m▀▀" + ansiReset)
+		default:
+			marks.WriteString("  ")
+		}
+	}
+	lines = append(lines, marks.String(), "")
+
+	day, _ := parseKey(t.fmtDate)
+	summary := newSummaryOfList(t.items)
+	lines = append(lines, fmt.Sprintf(ansiBold+"%s"+ansiReset+"  %.0f%% done", day.Format("Jan 2, 2006"), summary.PctCompl*100), "")
+
+	visible := t.visibleItems()
+	if len(visible) == 0 {
+		if t.fmtDate == today {
+			lines = append(lines, "No habits created yet! Press m to manage habits.")
+		} else {
+			lines = append(lines, "You didn't have any habits set on this day.")
+		}
+	}
+	for n, i := range visible {
+		item := &t.items[i]
+		mark := "[ ]"
+		if item.isDone() {
+			mark = "[x]"
+		}
+		var details []string
+		if item.isQuantity() {
+			progress := fmtQuantity(item.Value) + "/" + fmtQuantity(item.Target)
+			if item.Unit != "" {
+				progress += " " + item.Unit
+			}
+			details = append(details, progress)
+		}
+		if item.Schedule.isWeeklyGoal() {
+			done := t.weekCompl[item.ID]
+			if item.isDone() {
+				done++
+			}
+			details = append(details, fmt.Sprintf("%d/%d this week", done, item.Schedule.PerWeek))
+		}
+		if st := t.streaks[item.ID].String(); st != "" {
+			details = append(details, st)
+		}
+		ln := mark + " " + item.Content
+		if n == t.cursor {
+			ln = ansiReverse + mark + ansiReset + " " + ansiBold + item.Content + ansiReset
+		}
+		if len(details) > 0 {
+			ln += "  " + ansiMuted + strings.Join(details, ", ") + ansiReset
+		}
+		lines = append(lines, "  "+ln)
+	}
+	return lines
+}
+
+// habitLines draws the habit template list.
+func (t *tui) habitLines() []string {
+	lines := []string{"", ansiBold + "Manage Habits" + ansiReset, ""}
+	if len(t.templates) == 0 {
+		lines = append(lines, "No habits yet. Press a to add one.")
+	}
+	for i := range t.templates {
+		h := &t.templates[i]
+		ln := h.Content
+		if detail := habitDetail(h); detail != "" {
+			ln += "  " + ansiMuted + detail + ansiReset
+		}
+		if st := t.streaks[h.ID].String(); st != "" && !h.isDeleted() {
+			ln += "  " + ansiMuted + st + ansiReset
+		}
+		if h.isDeleted() {
+			ln = ansiMuted + h.Content + "  (deleted " + h.DeletedAt.Format("Jan 2, 2006") + ")" + ansiReset
+		}
+		cursor := "  "
+		if i == t.habitCursor {
+			cursor = ansiBold + "> " + ansiReset
+		}
+		lines = append(lines, cursor+ln)
+	}
+	return lines
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// notifyResize does nothing where there's no signal for terminal resizes. The
+// terminal UI picks up the new size on the next key press instead.
+func notifyResize(c chan<- os.Signal) {}
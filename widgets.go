@@ -3,6 +3,7 @@ package main
 import (
 	"image"
 	"image/color"
+	"strconv"
 
 	"gioui.org/layout"
 	"gioui.org/op"
@@ -14,11 +15,19 @@ import (
 )
 
 var (
+	iconAdd         = mustIcon(icons.ContentAdd)
+	iconChart       = mustIcon(icons.EditorInsertChart)
 	iconChecked     = mustIcon(icons.ToggleCheckBox)
 	iconCheckCircle = mustIcon(icons.ActionCheckCircle)
+	iconDelete      = mustIcon(icons.ActionDelete)
+	iconDragHandle  = mustIcon(icons.EditorDragHandle)
+	iconEdit        = mustIcon(icons.EditorModeEdit)
 	iconError       = mustIcon(icons.AlertError)
 	iconEvent       = mustIcon(icons.ActionEvent)
+	iconExport      = mustIcon(icons.FileFileDownload)
 	iconInfo        = mustIcon(icons.ActionInfo)
+	iconRemove      = mustIcon(icons.ContentRemove)
+	iconRestore     = mustIcon(icons.ActionRestore)
 	iconUnchecked   = mustIcon(icons.ToggleCheckBoxOutlineBlank)
 	iconWarning     = mustIcon(icons.AlertWarning)
 )
@@ -32,6 +41,17 @@ func mustIcon(data []byte) *widget.Icon {
 	return ic
 }
 
+// mutedColor returns a dimmer version of the given color for secondary text.
+func mutedColor(c color.NRGBA) color.NRGBA {
+	c.A = c.A / 5 * 3
+	return c
+}
+
+// fmtQuantity formats a logged amount for a quantitative habit.
+func fmtQuantity(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
 type errorList struct {
 	errors []errWidget
 }
@@ -56,6 +76,42 @@ func (el *errorList) layout(gtx C, th *material.Theme) D {
 	return layout.Flex{Axis: layout.Vertical}.Layout(gtx, flexErrs...)
 }
 
+// notice is a dismissable message about something that went well.
+type notice struct {
+	msg     string
+	dismiss widget.Clickable
+}
+
+func (n *notice) set(msg string) {
+	n.msg = msg
+}
+
+func (n *notice) layout(gtx C, th *material.Theme) D {
+	if n.dismiss.Clicked() {
+		n.msg = ""
+	}
+	if n.msg == "" {
+		return D{}
+	}
+	dismissBtn := material.Button(th, &n.dismiss, "Dismiss")
+	dismissBtn.Inset = layout.Inset{Top: 5, Right: 10, Bottom: 5, Left: 10}
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(rule{color: th.ContrastBg}.layout),
+		layout.Rigid(func(gtx C) D {
+			return layout.UniformInset(10).Layout(gtx, func(gtx C) D {
+				return layout.Flex{Alignment: layout.Middle}.Layout(gtx,
+					layout.Rigid(func(gtx C) D {
+						return iconInfo.Layout(gtx, th.ContrastBg)
+					}),
+					layout.Rigid(layout.Spacer{Width: 10}.Layout),
+					layout.Flexed(1, material.Body1(th, n.msg).Layout),
+					layout.Rigid(dismissBtn.Layout),
+				)
+			})
+		}),
+	)
+}
+
 type errWidget struct {
 	desc    string
 	err     error